@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/holoplot/go-avahi"
+	"ldddns.arnested.dk/internal/log"
+)
+
+// avahiBusName is the D-Bus name owned by avahi-daemon.
+const avahiBusName = "org.freedesktop.Avahi"
+
+var errAvahiGone = errors.New("avahi-daemon left the bus")
+
+// watchAvahi watches avahi-daemon coming and going on the bus. When it
+// comes back the Avahi server is recreated and all entry groups are
+// rebuilt from the current containers.
+func watchAvahi(
+	ctx context.Context,
+	conn *dbus.Conn,
+	config Config,
+	containerEngines engines,
+	egs *entryGroups,
+	dns *dnsServer,
+	status *daemonStatus,
+) error {
+	err := conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, avahiBusName),
+	)
+	if err != nil {
+		return fmt.Errorf("watching for %s on the bus: %w", avahiBusName, err)
+	}
+
+	signals := make(chan *dbus.Signal, 10) //nolint:mnd
+	conn.Signal(signals)
+
+	go func() {
+		defer conn.RemoveSignal(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case signal, ok := <-signals:
+				if !ok {
+					return
+				}
+
+				newOwner, changed := avahiOwnerChange(signal)
+				if !changed {
+					continue
+				}
+
+				if newOwner == "" {
+					log.Logf(log.PriWarning, "Avahi left the bus, waiting for it to come back")
+					status.degraded("Avahi", errAvahiGone)
+
+					continue
+				}
+
+				log.Logf(log.PriNotice, "Avahi (re)appeared on the bus, re-registering everything")
+
+				err := reregisterAvahi(ctx, config, containerEngines, egs, dns)
+				if err != nil {
+					log.Logf(log.PriErr, "re-registering with Avahi: %v", err)
+					status.degraded("Avahi", err)
+
+					continue
+				}
+
+				status.recovered("Avahi")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// avahiOwnerChange returns the new owner of the Avahi bus name if the
+// signal is a change of the owner.
+func avahiOwnerChange(signal *dbus.Signal) (string, bool) {
+	if signal.Name != "org.freedesktop.DBus.NameOwnerChanged" {
+		return "", false
+	}
+
+	var name, oldOwner, newOwner string
+
+	err := dbus.Store(signal.Body, &name, &oldOwner, &newOwner)
+	if err != nil || name != avahiBusName {
+		return "", false
+	}
+
+	return newOwner, true
+}
+
+// newAvahiServer creates an Avahi server on its own connection to the
+// system bus. Closing the server closes the connection so it must not
+// be shared, i.e. with the watching of avahi-daemon.
+func newAvahiServer() (*avahi.Server, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to dbus system bus: %w", err)
+	}
+
+	avahiServer, err := avahi.ServerNew(conn)
+	if err != nil {
+		conn.Close()
+
+		return nil, fmt.Errorf("avahi new failed: %w", err)
+	}
+
+	return avahiServer, nil
+}
+
+// reregisterAvahi creates a new Avahi server replacing the one that
+// died with the old avahi-daemon and republishes all containers.
+func reregisterAvahi(
+	ctx context.Context,
+	config Config,
+	containerEngines engines,
+	egs *entryGroups,
+	dns *dnsServer,
+) error {
+	avahiServer, err := newAvahiServer()
+	if err != nil {
+		return err
+	}
+
+	egs.replaceServer(avahiServer)
+
+	for _, docker := range containerEngines {
+		handleExistingContainers(ctx, config, docker, egs, dns)
+	}
+
+	return nil
+}
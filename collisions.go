@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/holoplot/go-avahi"
+	"ldddns.arnested.dk/internal/log"
+)
+
+// Policies for handling name collisions with other hosts on the
+// network.
+const (
+	collisionPolicyRename = "rename"
+	collisionPolicyIgnore = "ignore"
+)
+
+// maxCollisionRenames is how many alternative names we try before
+// giving up on a container.
+const maxCollisionRenames = 10
+
+// entryGroup is the Avahi entry group of a container along with what
+// we need to know to handle collisions.
+type entryGroup struct {
+	*avahi.EntryGroup
+
+	containerName string
+	// collisions is the number of times the entry group has
+	// collided and been renamed.
+	collisions int
+	mutex      sync.Mutex
+	stop       chan struct{}
+}
+
+func newEntryGroup(avahiEntryGroup *avahi.EntryGroup) *entryGroup {
+	return &entryGroup{
+		EntryGroup:    avahiEntryGroup,
+		containerName: "",
+		collisions:    0,
+		mutex:         sync.Mutex{},
+		stop:          make(chan struct{}),
+	}
+}
+
+// setContainerName sets the container name used when logging about
+// the entry group.
+func (g *entryGroup) setContainerName(containerName string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.containerName = containerName
+}
+
+// resetCollisions makes the entry group use the original names again.
+func (g *entryGroup) resetCollisions() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.collisions = 0
+}
+
+// hostname returns the hostname to publish, i.e. `myapp-2.local`
+// instead of `myapp.local` after a collision.
+func (g *entryGroup) hostname(hostname string) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return alternativeHostname(hostname, g.collisions)
+}
+
+// serviceName returns the service name to publish, i.e. `myapp #2`
+// instead of `myapp` after a collision.
+func (g *entryGroup) serviceName(name string) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return alternativeServiceName(name, g.collisions)
+}
+
+// alternativeHostname follows the RFC 6762 pattern of appending a
+// number to the first label (`myapp.local` becomes `myapp-2.local`).
+func alternativeHostname(hostname string, collisions int) string {
+	if collisions == 0 {
+		return hostname
+	}
+
+	label, domain, found := strings.Cut(hostname, ".")
+	alternative := fmt.Sprintf("%s-%d", label, collisions+1)
+
+	if found {
+		alternative += "." + domain
+	}
+
+	return alternative
+}
+
+// alternativeServiceName follows Avahi's pattern for alternative
+// service names (`myapp` becomes `myapp #2`).
+func alternativeServiceName(name string, collisions int) string {
+	if collisions == 0 {
+		return name
+	}
+
+	return suffixedLabel(name, fmt.Sprintf(" #%d", collisions+1))
+}
+
+// watch the state changes of a container's entry group until it is
+// stopped. Avahi stops dispatching all signals if the state changes
+// are not read, so we always keep reading them.
+func (e *entryGroups) watch(containerID string, group *entryGroup) {
+	for {
+		select {
+		case <-group.stop:
+			return
+		case state := <-group.StateChangeChannel:
+			switch state.State {
+			case avahi.EntryGroupEstablished:
+				log.Logf(log.PriDebug, "entry group for container ID %s established", containerID)
+			case avahi.EntryGroupCollision:
+				e.collided(containerID, group)
+			case avahi.EntryGroupFailure:
+				group.mutex.Lock()
+				log.Logf(log.PriErr, "Publishing names for container %s failed: %s", group.containerName, state.Error)
+				group.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// collided handles a name collision with another host on the network.
+// Avahi doesn't tell which of the entries collided so all names of the
+// entry group get an alternative name.
+func (e *entryGroups) collided(containerID string, group *entryGroup) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if e.config.CollisionPolicy != collisionPolicyRename {
+		log.Logf(log.PriWarning, "Names of container %s collide with another host on the network", group.containerName)
+
+		return
+	}
+
+	if group.collisions >= maxCollisionRenames {
+		log.Logf(
+			log.PriErr,
+			"Names of container %s still collide with another host on the network after %d renames, giving up",
+			group.containerName,
+			group.collisions,
+		)
+
+		return
+	}
+
+	group.collisions++
+
+	log.Logf(
+		log.PriWarning,
+		"Names of container %s collide with another host on the network, retrying with alternative names (attempt %d)",
+		group.containerName,
+		group.collisions,
+	)
+
+	e.requeue.push(containerID)
+}
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
+	"slices"
 	"syscall"
 	"time"
 
@@ -16,16 +16,31 @@ import (
 	"ldddns.arnested.dk/internal/log"
 )
 
+const (
+	// reconnectMinBackoff is the initial wait before reconnecting
+	// to Docker.
+	reconnectMinBackoff = time.Second
+	// reconnectMaxBackoff is the longest wait between reconnect
+	// attempts.
+	reconnectMaxBackoff = time.Minute
+	// requeueRetryDelay is the wait before handling a requeued
+	// container again when we could not inspect it.
+	requeueRetryDelay = 5 * time.Second
+)
+
 //nolint:cyclop
 func handleContainer(
 	ctx context.Context,
-	docker *client.Client,
+	docker *engine,
 	containerID string,
 	egs *entryGroups,
+	dns *dnsServer,
 	status events.Action,
 	config Config,
 ) error {
-	entryGroup, commit, err := egs.get(containerID)
+	key := docker.key(containerID)
+
+	entryGroup, commit, err := egs.get(key)
 	defer commit()
 
 	if err != nil {
@@ -44,7 +59,23 @@ func handleContainer(
 		}
 	}
 
+	dns.remove(key)
+
+	// Until the container claims its names again, i.e. if it died or
+	// we fail to look it up, its names fail over to other containers.
+	claimed := false
+
+	defer func() {
+		if !claimed {
+			egs.requeue.push(egs.owners.release(key)...)
+		}
+	}()
+
 	if status == "die" || status == "kill" || status == "pause" {
+		// Next time the container is started we try the original
+		// names again.
+		entryGroup.resetCollisions()
+
 		return nil
 	}
 
@@ -54,32 +85,153 @@ func handleContainer(
 	}
 
 	containerInfo := internalContainer.Container{InspectResponse: result.Container}
+	config = containerConfig(containerInfo, config)
 
-	if ignoreOneoff(containerInfo, config) {
+	entryGroup.setContainerName(containerInfo.Name())
+
+	if !enabled(containerInfo, config) || ignoreOneoff(containerInfo, config) {
 		return nil
 	}
 
-	ipNumbers := containerInfo.IPAddresses()
+	ipNumbers, err := containerIPAddresses(ctx, docker, containerInfo, config)
+	if err != nil {
+		return err
+	}
+
 	if len(ipNumbers) == 0 {
 		return nil
 	}
 
-	hostnames, err := hostname.Hostnames(containerInfo, config.HostnameLookup)
+	hostnames, fqdns, err := containerNames(ctx, docker, containerInfo, config, dns)
 	if err != nil {
-		return fmt.Errorf("getting hostnames: %w", err)
+		return err
 	}
 
+	hostnames, fqdns = claimNames(egs, dns, newHostnameClaim(key, containerInfo), hostnames, fqdns)
+	claimed = true
+
 	for _, hostname := range hostnames {
-		addAddress(entryGroup, hostname, ipNumbers)
+		addAddress(entryGroup.EntryGroup, entryGroup.hostname(hostname), ipNumbers)
+	}
+
+	if len(hostnames) > 0 {
+		addContainerServices(egs, entryGroup, containerInfo, config, hostnames, ipNumbers)
 	}
 
-	if services := containerInfo.Services(); len(hostnames) > 0 {
-		addServices(entryGroup, hostnames[0], ipNumbers, services, containerInfo.Name())
+	if config.Wildcard && dns == nil {
+		log.Logf(
+			log.PriWarning,
+			"Not publishing wildcard names for %s: Avahi mDNS cannot publish wildcards, enable the DNS server for that",
+			containerInfo.Name(),
+		)
 	}
 
+	dns.publish(key, fqdns, containerInfo, config, ipNumbers)
+
 	return nil
 }
 
+// containerNames returns the hostnames of the container on mDNS and
+// its names on the DNS server. A pod's infra container gets the name
+// of the pod too.
+func containerNames(
+	ctx context.Context,
+	docker *engine,
+	containerInfo internalContainer.Container,
+	config Config,
+	dns *dnsServer,
+) ([]string, []string, error) {
+	domain := config.domain()
+
+	hostnames, err := hostname.Hostnames(containerInfo, config.HostnameLookup, config.RewriteRules, domain)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting hostnames: %w", err)
+	}
+
+	podName := docker.podName(ctx, containerInfo.ID)
+	if podName != "" {
+		podHostname := hostname.RewriteHostname(podName+"."+domain, domain)
+
+		if !slices.Contains(hostnames, podHostname) {
+			hostnames = append(hostnames, podHostname)
+		}
+	}
+
+	fqdns, err := dns.fqdns(containerInfo, config, podName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting names for the DNS server: %w", err)
+	}
+
+	return hostnames, fqdns, nil
+}
+
+// claimNames claims the container's hostnames on mDNS and names on the
+// DNS server alike and returns those the container owns. The
+// containers affected by the claim are republished. The containers we
+// take names from are withdrawn first or Avahi would reject our
+// hostnames as a local collision. Must be called with the lock held.
+func claimNames(
+	egs *entryGroups,
+	dns *dnsServer,
+	claim hostnameClaim,
+	hostnames []string,
+	fqdns []string,
+) ([]string, []string) {
+	owned, changed, taken := egs.owners.claim(claim, append(slices.Clone(hostnames), fqdns...))
+
+	egs.withdraw(taken)
+
+	for _, key := range taken {
+		dns.remove(key)
+	}
+
+	egs.requeue.push(changed...)
+
+	return ownedNames(hostnames, owned), ownedNames(fqdns, owned)
+}
+
+// ownedNames returns the names in the order given that are owned.
+func ownedNames(names []string, owned []string) []string {
+	return slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		return !slices.Contains(owned, name)
+	})
+}
+
+// removeContainer forgets everything about the removed container.
+func removeContainer(docker *engine, containerID string, egs *entryGroups, dns *dnsServer) {
+	key := docker.key(containerID)
+
+	egs.remove(key)
+	dns.remove(key)
+	egs.requeue.push(egs.owners.release(key)...)
+	hostname.Forget(containerID)
+}
+
+// containerIPAddresses returns the IP addresses of the container. A
+// container sharing the network of another container, i.e. a member
+// of a Podman pod, gets the IP addresses of that container.
+func containerIPAddresses(
+	ctx context.Context,
+	docker *engine,
+	containerInfo internalContainer.Container,
+	config Config,
+) ([]string, error) {
+	if containerInfo.HostConfig == nil || !containerInfo.HostConfig.NetworkMode.IsContainer() {
+		return ipAddresses(containerInfo, config.IPFamily, config.Networks), nil
+	}
+
+	networkContainer := containerInfo.HostConfig.NetworkMode.ConnectedContainer()
+
+	result, err := docker.ContainerInspect(ctx, networkContainer, client.ContainerInspectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("inspecting network container %s: %w", networkContainer, err)
+	}
+
+	networkContainerInfo := internalContainer.Container{InspectResponse: result.Container}
+
+	return ipAddresses(networkContainerInfo, config.IPFamily, config.Networks), nil
+}
+
 func ignoreOneoff(containerInfo internalContainer.Container, config Config) bool {
 	if !config.IgnoreDockerComposeOneoff {
 		return false
@@ -99,14 +251,22 @@ func ignoreOneoff(containerInfo internalContainer.Container, config Config) bool
 	return true
 }
 
-func handleExistingContainers(ctx context.Context, config Config, docker *client.Client, egs *entryGroups) {
-	result, err := docker.ContainerList(ctx, client.ContainerListOptions{})
+func handleExistingContainers(
+	ctx context.Context,
+	config Config,
+	docker *engine,
+	egs *entryGroups,
+	dns *dnsServer,
+) {
+	filter := enabledFilter(make(client.Filters), config)
+
+	result, err := docker.ContainerList(ctx, client.ContainerListOptions{Filters: filter})
 	if err != nil {
 		log.Logf(log.PriErr, "getting container list: %v", err)
 	}
 
 	for _, container := range result.Items {
-		err = handleContainer(ctx, docker, container.ID, egs, "start", config)
+		err = handleContainer(ctx, docker, container.ID, egs, dns, "start", config)
 		if err != nil {
 			log.Logf(log.PriErr, "handling container: %v", err)
 
@@ -115,35 +275,181 @@ func handleExistingContainers(ctx context.Context, config Config, docker *client
 	}
 }
 
-func listen(ctx context.Context, config Config, docker *client.Client, egs *entryGroups, started time.Time) {
+func listen(
+	ctx context.Context,
+	config Config,
+	docker *engine,
+	egs *entryGroups,
+	dns *dnsServer,
+	status *daemonStatus,
+	started time.Time,
+) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+
+	since := started
+
+	for {
+		var err error
+
+		since, err = listenEvents(ctx, config, docker, egs, dns, since, sig)
+		if err == nil {
+			return
+		}
+
+		log.Logf(log.PriErr, "Lost connection to Docker at %s: %v", docker.endpoint, err)
+		status.degraded(docker.component(), err)
+
+		if !waitForDocker(ctx, docker, sig) {
+			return
+		}
+
+		log.Logf(log.PriNotice, "Reconnected to Docker at %s, resuming events since %s", docker.endpoint, since)
+		status.recovered(docker.component())
+
+		// Docker might have lost its event history (i.e. if the
+		// daemon restarted) so we do a full resync.
+		handleExistingContainers(ctx, config, docker, egs, dns)
+		reconcile(ctx, config, docker, egs, dns)
+	}
+}
+
+// listenEvents handles Docker events since the given time until the
+// event stream fails or we are told to stop. It returns the time of
+// the last handled event and the error ending the stream, or nil if
+// we are stopping.
+func listenEvents(
+	ctx context.Context,
+	config Config,
+	docker *engine,
+	egs *entryGroups,
+	dns *dnsServer,
+	since time.Time,
+	sig <-chan os.Signal,
+) (time.Time, error) {
 	filter := make(client.Filters)
 	filter.Add("type", "container")
+	filter.Add("event", "destroy")
 	filter.Add("event", "die")
 	filter.Add("event", "kill")
 	filter.Add("event", "pause")
 	filter.Add("event", "start")
 	filter.Add("event", "unpause")
+	enabledFilter(filter, config)
+
+	if docker.podman != nil {
+		filter.Add("event", "died")
+		filter.Add("event", "remove")
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	result := docker.Events(ctx, client.EventsListOptions{
+	result := docker.Events(streamCtx, client.EventsListOptions{
 		Filters: filter,
-		Since:   strconv.FormatInt(started.Unix(), 10),
+		Since:   eventsSince(since),
 		Until:   "",
 	})
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGTERM)
-
 	for {
 		select {
 		case err := <-result.Err:
-			panic(fmt.Errorf("go error reading docker events: %w", err))
+			return since, fmt.Errorf("reading docker events: %w", err)
 		case msg := <-result.Messages:
-			err := handleContainer(ctx, docker, msg.Actor.ID, egs, msg.Action, config)
+			if msg.TimeNano != 0 {
+				since = time.Unix(0, msg.TimeNano)
+			}
+
+			action := eventAction(msg.Action)
+			if action == "destroy" {
+				removeContainer(docker, msg.Actor.ID, egs, dns)
+
+				continue
+			}
+
+			err := handleContainer(ctx, docker, msg.Actor.ID, egs, dns, action, config)
 			if err != nil {
 				log.Logf(log.PriErr, "handling container: %v", err)
 			}
 		case <-sig:
+			return since, nil
+		}
+	}
+}
+
+// requeueLoop handles the containers pushed onto the requeue, i.e.
+// after a name collision or when a hostname changes owner, until the
+// context is cancelled.
+func requeueLoop(
+	ctx context.Context,
+	config Config,
+	containerEngines engines,
+	egs *entryGroups,
+	dns *dnsServer,
+) {
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-egs.requeue.ready:
+			for _, key := range egs.requeue.drain() {
+				docker, containerID, ok := containerEngines.lookup(key)
+				if !ok {
+					continue
+				}
+
+				// The container might have been stopped or
+				// removed since it was pushed.
+				status, err := reconcileStatus(ctx, docker, containerID)
+				if err != nil {
+					log.Logf(log.PriWarning, "Requeueing container: %v", err)
+					time.AfterFunc(requeueRetryDelay, func() { egs.requeue.push(key) })
+
+					continue
+				}
+
+				if status == "destroy" {
+					removeContainer(docker, containerID, egs, dns)
+
+					continue
+				}
+
+				err = handleContainer(ctx, docker, containerID, egs, dns, status, config)
+				if err != nil {
+					log.Logf(log.PriErr, "handling requeued container: %v", err)
+				}
+			}
 		}
 	}
 }
+
+// eventsSince formats a time as the `since` option of the Docker
+// events API with nanosecond precision.
+func eventsSince(since time.Time) string {
+	return fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+}
+
+// waitForDocker pings Docker with an exponential backoff until it
+// answers. It returns false if we are told to stop while waiting.
+func waitForDocker(ctx context.Context, docker *engine, sig <-chan os.Signal) bool {
+	backoff := reconnectMinBackoff
+
+	for {
+		select {
+		case <-sig:
+			return false
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+
+		_, err := docker.Ping(ctx, client.PingOptions{})
+		if err == nil {
+			return true
+		}
+
+		backoff = min(backoff*2, reconnectMaxBackoff)
+
+		log.Logf(log.PriDebug, "Docker still unavailable, retrying in %s: %v", backoff, err)
+	}
+}
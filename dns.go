@@ -1,15 +1,24 @@
 package main
 
 import (
+	"fmt"
 	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/holoplot/go-avahi"
+	internalContainer "ldddns.arnested.dk/internal/container"
 	"ldddns.arnested.dk/internal/log"
 )
 
 const (
 	iface = int32(net.FlagUp)
 	tld   = "local"
+	// maxLabelLength is the maximum length of a DNS label, i.e. a
+	// service instance name (RFC 1035 section 2.3.4).
+	maxLabelLength = 63
 )
 
 func addAddress(entryGroup *avahi.EntryGroup, hostname string, ipNumbers []string) {
@@ -18,7 +27,7 @@ func addAddress(entryGroup *avahi.EntryGroup, hostname string, ipNumbers []strin
 			continue
 		}
 
-		err := entryGroup.AddAddress(iface, avahi.ProtoInet, uint32(net.FlagMulticast), hostname, ipNumber)
+		err := entryGroup.AddAddress(iface, protocol(ipNumber), uint32(net.FlagMulticast), hostname, ipNumber)
 		if err != nil {
 			log.Logf(log.PriErr, "addAddess() failed: %v", err)
 
@@ -29,23 +38,30 @@ func addAddress(entryGroup *avahi.EntryGroup, hostname string, ipNumbers []strin
 	}
 }
 
-func addServices(entryGroup *avahi.EntryGroup, hostname string, ips []string, services map[string]uint16, name string) {
-	for _, ip := range ips {
-		if ip == "" {
-			continue
-		}
-
+func addServices(
+	entryGroup *avahi.EntryGroup,
+	domain string,
+	hostname string,
+	protos []int32,
+	services map[internalContainer.Service]uint16,
+	names map[internalContainer.Service]string,
+	txt map[internalContainer.Service][][]byte,
+	subtypes map[internalContainer.Service][]string,
+) {
+	for _, proto := range protos {
 		for service, portNumber := range services {
+			serviceName := names[service]
+
 			err := entryGroup.AddService(
 				iface,
-				avahi.ProtoInet,
+				proto,
 				0,
-				name,
-				service,
-				tld,
+				serviceName,
+				service.Type,
+				domain,
 				hostname,
 				portNumber,
-				nil,
+				txt[service],
 			)
 			if err != nil {
 				log.Logf(log.PriErr, "AddService() failed: %v", err)
@@ -53,7 +69,177 @@ func addServices(entryGroup *avahi.EntryGroup, hostname string, ips []string, se
 				continue
 			}
 
-			log.Logf(log.PriDebug, "added service %q pointing to %q", service, hostname)
+			log.Logf(log.PriDebug, "added service %q on port %d pointing to %q", service.Type, portNumber, hostname)
+
+			for _, subtype := range subtypes[service] {
+				err := entryGroup.AddServiceSubtype(iface, proto, 0, serviceName, service.Type, domain, subtype)
+				if err != nil {
+					log.Logf(log.PriErr, "AddServiceSubtype() failed: %v", err)
+
+					continue
+				}
+
+				log.Logf(log.PriDebug, "added subtype %q of service %q", subtype, service.Type)
+			}
+		}
+	}
+}
+
+// addContainerServices adds the services of a container. Depending on
+// the service ports mode they point at the container's hostnames or at
+// the host's name.
+func addContainerServices(
+	egs *entryGroups,
+	entryGroup *entryGroup,
+	containerInfo internalContainer.Container,
+	config Config,
+	hostnames []string,
+	ipNumbers []string,
+) {
+	services := containerInfo.Services()
+	txt := serviceTXTRecords(containerInfo, config.TXTRecords, services)
+	subtypes := serviceSubtypes(containerInfo, services)
+	name := entryGroup.serviceName(containerInfo.Name())
+	containerServices, hostServices := splitServices(containerInfo, services, config.ServicePorts)
+
+	if config.ServiceHostnames == serviceHostnamesFirst {
+		hostnames = hostnames[:1]
+	}
+
+	for i, hostname := range hostnames {
+		addServices(
+			entryGroup.EntryGroup,
+			config.domain(),
+			entryGroup.hostname(hostname),
+			protocols(ipNumbers),
+			containerServices,
+			serviceNames(entryGroup.serviceName(instanceName(containerInfo.Name(), hostname, i)), services),
+			txt,
+			subtypes,
+		)
+	}
+
+	if len(hostServices) == 0 {
+		return
+	}
+
+	hostFQDN, err := egs.hostFQDN()
+	if err != nil {
+		log.Logf(log.PriErr, "Not announcing host ports of %s: %v", containerInfo.Name(), err)
+
+		return
+	}
+
+	// The host is reachable on all address families.
+	addServices(
+		entryGroup.EntryGroup,
+		config.domain(),
+		hostFQDN,
+		[]int32{avahi.ProtoUnspec},
+		hostServices,
+		serviceNames(name, services),
+		txt,
+		subtypes,
+	)
+}
+
+// instanceName returns the name of the service instances pointing at
+// the i'th hostname of a container. The first hostname gets the
+// container name, the others get the hostname added to be distinct.
+func instanceName(containerName string, hostname string, i int) string {
+	if i == 0 {
+		return suffixedLabel(containerName, "")
+	}
+
+	return suffixedLabel(containerName, " ("+hostname+")")
+}
+
+// suffixedLabel returns the name with the suffix added. The name is
+// cut short on a UTF-8 boundary so the label is at most 63 bytes.
+func suffixedLabel(name string, suffix string) string {
+	cut := maxLabelLength - len(suffix)
+	if cut < 0 {
+		name, suffix, cut = name+suffix, "", maxLabelLength
+	}
+
+	if len(name) > cut {
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+
+		name = strings.TrimRight(name[:cut], " ")
+	}
+
+	return name + suffix
+}
+
+// portInstanceName returns the instance name of a service. Services
+// of the same type on several ports must have distinct names so all
+// but the one on the lowest port get the port added, i.e. `myapp (port
+// 8080)`.
+func portInstanceName(name string, service internalContainer.Service, services []internalContainer.Service) string {
+	for _, other := range services {
+		if other.Type == service.Type && other.Port < service.Port {
+			return suffixedLabel(name, fmt.Sprintf(" (port %d)", service.Port))
 		}
 	}
+
+	return name
+}
+
+// serviceNames returns the instance name of each service. The names
+// are made distinct over all the services of the container, also when
+// they are split between the container's hostnames and the host.
+func serviceNames(name string, services []internalContainer.Service) map[internalContainer.Service]string {
+	names := make(map[internalContainer.Service]string, len(services))
+
+	for _, service := range services {
+		names[service] = portInstanceName(name, service, services)
+	}
+
+	return names
+}
+
+// serviceSubtypes returns the subtypes declared for each service.
+func serviceSubtypes(
+	containerInfo internalContainer.Container,
+	services []internalContainer.Service,
+) map[internalContainer.Service][]string {
+	subtypes := make(map[internalContainer.Service][]string, len(services))
+
+	for _, service := range services {
+		subtypes[service] = containerInfo.ServiceSubtypes(service.Type, service.Port)
+	}
+
+	return subtypes
+}
+
+// protocol returns the Avahi protocol matching the address family of
+// the IP number.
+func protocol(ipNumber string) int32 {
+	addr, err := netip.ParseAddr(ipNumber)
+	if err == nil && addr.Is6() && !addr.Is4In6() {
+		return avahi.ProtoInet6
+	}
+
+	return avahi.ProtoInet
+}
+
+// protocols returns the Avahi protocols of the IP numbers. Each
+// protocol is only returned once so services are announced once per
+// address family.
+func protocols(ipNumbers []string) []int32 {
+	protos := []int32{}
+
+	for _, ipNumber := range ipNumbers {
+		if ipNumber == "" {
+			continue
+		}
+
+		if proto := protocol(ipNumber); !slices.Contains(protos, proto) {
+			protos = append(protos, proto)
+		}
+	}
+
+	return protos
 }
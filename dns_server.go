@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	internalContainer "ldddns.arnested.dk/internal/container"
+	"ldddns.arnested.dk/internal/hostname"
+	"ldddns.arnested.dk/internal/log"
+)
+
+const (
+	// dnsTTL is the time to live of the DNS answers. It is kept
+	// short because containers come and go all the time.
+	dnsTTL = 10
+	// dnsMaxUDPSize is the largest response sent over UDP. Larger
+	// responses are truncated so the client retries over TCP.
+	dnsMaxUDPSize = 512
+	// dnsTCPTimeout is how long an idle TCP connection is kept open.
+	dnsTCPTimeout = 10 * time.Second
+	// dnsServicesName is the DNS-SD service type enumeration name.
+	dnsServicesName = "_services._dns-sd._udp"
+)
+
+var errNotQuery = errors.New("not a DNS query")
+
+// dnsRecord is what the DNS server knows about a container.
+type dnsRecord struct {
+	hostnames []string
+	ips       []string
+	services  []internalContainer.Service
+	// wildcard makes any subdomain of the hostnames resolve too.
+	wildcard bool
+}
+
+// dnsServer is a unicast DNS server answering A, AAAA, SRV and PTR
+// queries for the container hostnames on a single domain.
+type dnsServer struct {
+	domain  string
+	records map[string]dnsRecord
+	mutex   sync.RWMutex
+}
+
+func newDNSServer(domain string) *dnsServer {
+	return &dnsServer{
+		domain:  domain,
+		records: make(map[string]dnsRecord),
+		mutex:   sync.RWMutex{},
+	}
+}
+
+// fqdns returns the names of the container on the DNS server: the
+// names found by the hostname lookups and the name of the pod the
+// container is the infra container of, like the hostnames published
+// on mDNS. A nil DNS server has no names.
+func (s *dnsServer) fqdns(containerInfo internalContainer.Container, config Config, podName string) ([]string, error) {
+	if s == nil {
+		return []string{}, nil
+	}
+
+	fqdns, err := hostname.FQDNs(containerInfo, config.HostnameLookup, config.RewriteRules, s.domain)
+	if err != nil {
+		return nil, fmt.Errorf("getting fully qualified domain names: %w", err)
+	}
+
+	if podName != "" {
+		podFQDN := hostname.RewriteFQDN(podName+"."+s.domain, s.domain)
+
+		if !slices.Contains(fqdns, podFQDN) {
+			fqdns = append(fqdns, podFQDN)
+		}
+	}
+
+	return fqdns, nil
+}
+
+// publish the container's names on the DNS server. Publishing on a nil
+// DNS server (i.e. when the DNS server is disabled) is a no-op.
+func (s *dnsServer) publish(
+	containerID string,
+	fqdns []string,
+	containerInfo internalContainer.Container,
+	config Config,
+	ipNumbers []string,
+) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[containerID] = dnsRecord{
+		hostnames: fqdns,
+		ips:       ipNumbers,
+		services:  containerInfo.Services(),
+		wildcard:  config.Wildcard,
+	}
+
+	log.Logf(log.PriDebug, "added DNS records for %q pointing to %q", fqdns, ipNumbers)
+
+	if config.Wildcard {
+		log.Logf(log.PriDebug, "added wildcard DNS records for subdomains of %q", fqdns)
+	}
+}
+
+// remove the container's records from the DNS server. Removing from a
+// nil DNS server is a no-op.
+func (s *dnsServer) remove(containerID string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, containerID)
+}
+
+// listen serves DNS over UDP and TCP on the address until the context
+// is cancelled.
+func (s *dnsServer) listen(ctx context.Context, address string) error {
+	var listenConfig net.ListenConfig
+
+	packetConn, err := listenConfig.ListenPacket(ctx, "udp", address)
+	if err != nil {
+		return fmt.Errorf("listening for DNS on UDP %s: %w", address, err)
+	}
+
+	listener, err := listenConfig.Listen(ctx, "tcp", address)
+	if err != nil {
+		packetConn.Close()
+
+		return fmt.Errorf("listening for DNS on TCP %s: %w", address, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		packetConn.Close()
+		listener.Close()
+	}()
+
+	go s.serveUDP(packetConn)
+	go s.serveTCP(listener)
+
+	log.Logf(log.PriNotice, "Serving DNS for %q on %s", s.domain, address)
+
+	return nil
+}
+
+func (s *dnsServer) serveUDP(conn net.PacketConn) {
+	buffer := make([]byte, math.MaxUint16)
+
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
+		if err != nil {
+			log.Logf(log.PriErr, "reading DNS request: %v", err)
+
+			continue
+		}
+
+		response, err := s.handle(buffer[:n], dnsMaxUDPSize)
+		if err != nil {
+			log.Logf(log.PriDebug, "handling DNS request from %s: %v", addr, err)
+
+			continue
+		}
+
+		_, err = conn.WriteTo(response, addr)
+		if err != nil {
+			log.Logf(log.PriErr, "writing DNS response: %v", err)
+		}
+	}
+}
+
+func (s *dnsServer) serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+
+		if err != nil {
+			log.Logf(log.PriErr, "accepting DNS connection: %v", err)
+
+			continue
+		}
+
+		go s.serveTCPConn(conn)
+	}
+}
+
+func (s *dnsServer) serveTCPConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		err := conn.SetDeadline(time.Now().Add(dnsTCPTimeout))
+		if err != nil {
+			return
+		}
+
+		var length uint16
+
+		err = binary.Read(conn, binary.BigEndian, &length)
+		if err != nil {
+			return
+		}
+
+		request := make([]byte, length)
+
+		_, err = io.ReadFull(conn, request)
+		if err != nil {
+			return
+		}
+
+		response, err := s.handle(request, math.MaxUint16)
+		if err != nil {
+			log.Logf(log.PriDebug, "handling DNS request from %s: %v", conn.RemoteAddr(), err)
+
+			return
+		}
+
+		_, err = conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(response))))
+		if err == nil {
+			_, err = conn.Write(response)
+		}
+
+		if err != nil {
+			log.Logf(log.PriErr, "writing DNS response: %v", err)
+
+			return
+		}
+	}
+}
+
+// handle a DNS request and return the packed response. Responses
+// larger than maxSize are truncated.
+func (s *dnsServer) handle(request []byte, maxSize int) ([]byte, error) {
+	var query dnsmessage.Message
+
+	err := query.Unpack(request)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking DNS request: %w", err)
+	}
+
+	if query.Response {
+		return nil, errNotQuery
+	}
+
+	response := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               query.ID,
+			Response:         true,
+			OpCode:           query.OpCode,
+			RecursionDesired: query.RecursionDesired,
+		},
+		Questions: query.Questions,
+	}
+
+	switch {
+	case query.OpCode != 0:
+		response.RCode = dnsmessage.RCodeNotImplemented
+	case len(query.Questions) != 1:
+		response.RCode = dnsmessage.RCodeFormatError
+	default:
+		response.RCode, response.Answers = s.resolve(query.Questions[0])
+		response.Authoritative = response.RCode != dnsmessage.RCodeRefused
+	}
+
+	packed, err := response.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("packing DNS response: %w", err)
+	}
+
+	if len(packed) > maxSize {
+		response.Truncated = true
+		response.Answers = nil
+
+		packed, err = response.Pack()
+		if err != nil {
+			return nil, fmt.Errorf("packing truncated DNS response: %w", err)
+		}
+	}
+
+	return packed, nil
+}
+
+// resolve a question into a response code and the answers.
+func (s *dnsServer) resolve(question dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+	name := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if addr, ok := reverseAddr(name); ok {
+		return s.resolveReverse(question, addr)
+	}
+
+	if name != s.domain && !strings.HasSuffix(name, "."+s.domain) {
+		return dnsmessage.RCodeRefused, nil
+	}
+
+	known := name == s.domain
+	answers := []dnsmessage.Resource{}
+
+	if name == dnsServicesName+"."+s.domain {
+		known = true
+
+		if question.Type == dnsmessage.TypePTR {
+			answers = s.serviceTypes(name)
+		}
+	}
+
+	for _, record := range s.records {
+		recordAnswers, recordKnows := record.answers(question.Type, name, s.domain)
+		answers = append(answers, recordAnswers...)
+		known = known || recordKnows
+	}
+
+	// Wildcards only apply to names no container has explicitly.
+	if !known {
+		answers, known = s.wildcardAnswers(question.Type, name)
+	}
+
+	if !known {
+		return dnsmessage.RCodeNameError, nil
+	}
+
+	return dnsmessage.RCodeSuccess, answers
+}
+
+// resolveReverse answers PTR questions for the container addresses.
+func (s *dnsServer) resolveReverse(
+	question dnsmessage.Question,
+	addr netip.Addr,
+) (dnsmessage.RCode, []dnsmessage.Resource) {
+	name := question.Name.String()
+	known := false
+	answers := []dnsmessage.Resource{}
+
+	for _, record := range s.records {
+		if len(record.hostnames) == 0 || !slices.ContainsFunc(record.ips, addrEqual(addr)) {
+			continue
+		}
+
+		known = true
+
+		if question.Type == dnsmessage.TypePTR {
+			answers = appendResource(answers, name, &dnsmessage.PTRResource{PTR: fqdn(record.hostnames[0])})
+		}
+	}
+
+	if !known {
+		return dnsmessage.RCodeNameError, nil
+	}
+
+	return dnsmessage.RCodeSuccess, answers
+}
+
+// serviceTypes answers the DNS-SD service type enumeration.
+func (s *dnsServer) serviceTypes(name string) []dnsmessage.Resource {
+	serviceTypes := []string{}
+
+	for _, record := range s.records {
+		for _, service := range record.services {
+			if !slices.Contains(serviceTypes, service.Type) {
+				serviceTypes = append(serviceTypes, service.Type)
+			}
+		}
+	}
+
+	answers := []dnsmessage.Resource{}
+
+	for _, service := range serviceTypes {
+		answers = appendResource(answers, name, &dnsmessage.PTRResource{PTR: fqdn(service + "." + s.domain)})
+	}
+
+	return answers
+}
+
+// answers returns the record's answers to a question for the name and
+// whether the record knows the name at all.
+func (r dnsRecord) answers(questionType dnsmessage.Type, name string, domain string) ([]dnsmessage.Resource, bool) {
+	answers := []dnsmessage.Resource{}
+	known := false
+
+	if slices.Contains(r.hostnames, name) {
+		known = true
+		answers = r.addressAnswers(questionType, name)
+	}
+
+	if len(r.hostnames) == 0 {
+		return answers, known
+	}
+
+	for _, service := range r.services {
+		serviceName := service.Type + "." + domain
+		instanceName := portInstanceName(r.instance(), service, r.services) + "." + serviceName
+
+		switch name {
+		case serviceName:
+			known = true
+
+			if questionType == dnsmessage.TypePTR {
+				answers = appendResource(answers, name, &dnsmessage.PTRResource{PTR: fqdn(instanceName)})
+			}
+		case instanceName:
+			known = true
+
+			if questionType == dnsmessage.TypeSRV {
+				answers = appendResource(answers, name, &dnsmessage.SRVResource{
+					Priority: 0,
+					Weight:   0,
+					Port:     service.Port,
+					Target:   fqdn(r.hostnames[0]),
+				})
+			}
+		}
+	}
+
+	return answers, known
+}
+
+// wildcardAnswers answers a question for a subdomain of the hostnames
+// of wildcard records. Only the most specific wildcard answers, i.e.
+// `*.api.shop.test` rather than `*.shop.test`. Must be called with the
+// lock held.
+func (s *dnsServer) wildcardAnswers(questionType dnsmessage.Type, name string) ([]dnsmessage.Resource, bool) {
+	answers := []dnsmessage.Resource{}
+	longest := ""
+
+	for _, containerID := range slices.Sorted(maps.Keys(s.records)) {
+		record := s.records[containerID]
+
+		hostname, ok := record.wildcardHostname(name)
+		if !ok || len(hostname) < len(longest) {
+			continue
+		}
+
+		if len(hostname) > len(longest) {
+			answers = []dnsmessage.Resource{}
+			longest = hostname
+		}
+
+		answers = append(answers, record.addressAnswers(questionType, name)...)
+	}
+
+	return answers, longest != ""
+}
+
+// wildcardHostname returns the longest of the record's hostnames the
+// name is a subdomain of if the record is a wildcard record.
+func (r dnsRecord) wildcardHostname(name string) (string, bool) {
+	if !r.wildcard {
+		return "", false
+	}
+
+	longest := ""
+
+	for _, hostname := range r.hostnames {
+		if strings.HasSuffix(name, "."+hostname) && len(hostname) > len(longest) {
+			longest = hostname
+		}
+	}
+
+	return longest, longest != ""
+}
+
+// addressAnswers returns the record's A or AAAA answers for the name.
+func (r dnsRecord) addressAnswers(questionType dnsmessage.Type, name string) []dnsmessage.Resource {
+	answers := []dnsmessage.Resource{}
+
+	for _, ipNumber := range r.ips {
+		addr, err := netip.ParseAddr(ipNumber)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case questionType == dnsmessage.TypeA && addr.Is4():
+			answers = appendResource(answers, name, &dnsmessage.AResource{A: addr.As4()})
+		case questionType == dnsmessage.TypeAAAA && addr.Is6():
+			answers = appendResource(answers, name, &dnsmessage.AAAAResource{AAAA: addr.As16()})
+		}
+	}
+
+	return answers
+}
+
+// instance is the DNS-SD service instance name of the record.
+func (r dnsRecord) instance() string {
+	instance, _, _ := strings.Cut(r.hostnames[0], ".")
+
+	return instance
+}
+
+func appendResource(answers []dnsmessage.Resource, name string, body dnsmessage.ResourceBody) []dnsmessage.Resource {
+	return append(answers, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  fqdn(name),
+			Class: dnsmessage.ClassINET,
+			TTL:   dnsTTL,
+		},
+		Body: body,
+	})
+}
+
+// fqdn converts a name into a DNS message name. Names too long to be
+// valid domain names becomes the root name.
+func fqdn(name string) dnsmessage.Name {
+	dnsName, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		log.Logf(log.PriErr, "invalid DNS name %q: %v", name, err)
+
+		return dnsmessage.MustNewName(".")
+	}
+
+	return dnsName
+}
+
+func addrEqual(addr netip.Addr) func(string) bool {
+	return func(ipNumber string) bool {
+		other, err := netip.ParseAddr(ipNumber)
+
+		return err == nil && other == addr
+	}
+}
+
+// reverseAddr parses a reverse lookup name (in `in-addr.arpa` or
+// `ip6.arpa`) into the address it is the reverse of.
+func reverseAddr(name string) (netip.Addr, bool) {
+	if reversed, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		labels := strings.Split(reversed, ".")
+
+		//nolint:mnd
+		if len(labels) != 4 {
+			return netip.Addr{}, false
+		}
+
+		slices.Reverse(labels)
+		addr, err := netip.ParseAddr(strings.Join(labels, "."))
+
+		return addr, err == nil && addr.Is4()
+	}
+
+	if reversed, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		nibbles := strings.Split(reversed, ".")
+
+		//nolint:mnd
+		if len(nibbles) != 32 {
+			return netip.Addr{}, false
+		}
+
+		var bytes [16]byte
+
+		for i, nibble := range nibbles {
+			value, err := strconv.ParseUint(nibble, 16, 8)
+			if err != nil || len(nibble) != 1 {
+				return netip.Addr{}, false
+			}
+
+			// The nibbles are in reverse order, least significant first.
+			bytes[15-i/2] |= byte(value) << (4 * (i % 2))
+		}
+
+		return netip.AddrFrom16(bytes), true
+	}
+
+	return netip.Addr{}, false
+}
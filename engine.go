@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/moby/moby/api/types/events"
+	"github.com/moby/moby/api/types/system"
+	"github.com/moby/moby/client"
+	"ldddns.arnested.dk/internal/log"
+	"ldddns.arnested.dk/internal/podman"
+)
+
+// engine is a container engine: Docker or Podman through its Docker
+// compatible API.
+type engine struct {
+	*client.Client
+
+	// endpoint is the host of the engine, i.e.
+	// `unix:///var/run/docker.sock`.
+	endpoint string
+
+	// podman is the native API of Podman. It is nil if the engine
+	// is not Podman.
+	podman *podman.Client
+}
+
+// newEngine connects to the container engine at `host`. If `host` is
+// empty the engine configured in the environment is used, i.e.
+// `DOCKER_HOST`. If nothing is configured and Docker isn't running we
+// look for a Podman socket instead. An explicit `host` doesn't use the
+// environment, so i.e. `DOCKER_CERT_PATH` only applies to the engine
+// configured there.
+func newEngine(ctx context.Context, host string) (*engine, error) {
+	opts := []client.Opt{client.WithHost(host)}
+
+	if host == "" {
+		opts = []client.Opt{client.FromEnv}
+
+		if podmanSocket := podmanHost(); podmanSocket != "" {
+			log.Logf(log.PriNotice, "Docker socket not found, using Podman at %s", podmanSocket)
+
+			opts = append(opts, client.WithHost(podmanSocket))
+		}
+	}
+
+	docker, err := client.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+
+	containerEngine := &engine{Client: docker, endpoint: docker.DaemonHost(), podman: nil}
+
+	version, err := docker.ServerVersion(ctx, client.ServerVersionOptions{})
+	if err != nil {
+		log.Logf(
+			log.PriWarning,
+			"Could not detect container engine at %s, assuming Docker: %v",
+			containerEngine.endpoint,
+			err,
+		)
+
+		return containerEngine, nil
+	}
+
+	if isPodman(version) {
+		containerEngine.podman, err = podman.New(docker.DaemonHost())
+		if err != nil {
+			log.Logf(log.PriWarning, "Cannot use the native Podman API, pods will not be published: %v", err)
+		}
+	}
+
+	return containerEngine, nil
+}
+
+// newEngines connects to the container engines at the endpoints. With
+// no endpoints it connects to the engine configured in the environment.
+func newEngines(ctx context.Context, endpoints []string) (engines, error) {
+	if len(endpoints) == 0 {
+		endpoints = []string{""}
+	}
+
+	containerEngines := make(engines, 0, len(endpoints))
+
+	for _, endpoint := range endpoints {
+		containerEngine, err := newEngine(ctx, endpoint)
+		if err != nil {
+			containerEngines.close()
+
+			return nil, fmt.Errorf("connecting to %q: %w", endpoint, err)
+		}
+
+		containerEngines = append(containerEngines, containerEngine)
+	}
+
+	return containerEngines, nil
+}
+
+// key returns the key of a container of the engine. Container IDs are
+// only unique per engine so we namespace them by the endpoint.
+func (e *engine) key(containerID string) string {
+	return e.endpoint + "/" + containerID
+}
+
+// containerID returns the container ID of a key if the container
+// belongs to the engine.
+func (e *engine) containerID(key string) (string, bool) {
+	separator := strings.LastIndex(key, "/")
+	if separator < 0 || key[:separator] != e.endpoint {
+		return "", false
+	}
+
+	return key[separator+1:], true
+}
+
+// component is the name of the engine in the daemon status.
+func (e *engine) component() string {
+	return "Docker (" + e.endpoint + ")"
+}
+
+// engines are the container engines we watch.
+type engines []*engine
+
+// lookup returns the engine and container ID of a container key.
+func (e engines) lookup(key string) (*engine, string, bool) {
+	for _, containerEngine := range e {
+		if containerID, ok := containerEngine.containerID(key); ok {
+			return containerEngine, containerID, true
+		}
+	}
+
+	return nil, "", false
+}
+
+// close the connections to all engines.
+func (e engines) close() {
+	for _, containerEngine := range e {
+		err := containerEngine.Close()
+		if err != nil {
+			log.Logf(log.PriErr, "closing connection to %s: %v", containerEngine.endpoint, err)
+		}
+	}
+}
+
+// podmanHost returns the host of the system Podman socket if neither
+// `DOCKER_HOST` is set nor the Docker socket exists.
+func podmanHost() string {
+	if os.Getenv(client.EnvOverrideHost) != "" {
+		return ""
+	}
+
+	if _, err := os.Stat(client.DefaultDockerHost[len("unix://"):]); err == nil {
+		return ""
+	}
+
+	if _, err := os.Stat(podman.Socket); err == nil {
+		return "unix://" + podman.Socket
+	}
+
+	return ""
+}
+
+// isPodman tells whether the engine is Podman.
+func isPodman(version client.ServerVersionResult) bool {
+	return slices.ContainsFunc(version.Components, func(component system.ComponentVersion) bool {
+		return component.Name == podman.EngineName
+	})
+}
+
+// podName returns the name of the pod if the container is the infra
+// container of a Podman pod. Otherwise it returns an empty string.
+func (e *engine) podName(ctx context.Context, containerID string) string {
+	if e.podman == nil {
+		return ""
+	}
+
+	pod, err := e.podman.ContainerPod(ctx, containerID)
+	if err != nil {
+		log.Logf(log.PriErr, "Could not look up pod of container %s: %v", containerID, err)
+
+		return ""
+	}
+
+	if pod.InfraContainerID != containerID {
+		return ""
+	}
+
+	return pod.Name
+}
+
+// eventAction translates the actions of Podman events into the
+// actions of the corresponding Docker events.
+func eventAction(action events.Action) events.Action {
+	switch action {
+	case "died":
+		return events.ActionDie
+	case "remove":
+		return events.ActionDestroy
+	default:
+		return action
+	}
+}
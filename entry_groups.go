@@ -10,19 +10,29 @@ import (
 
 type entryGroups struct {
 	avahiServer *avahi.Server
-	groups      map[string]*avahi.EntryGroup
+	groups      map[string]*entryGroup
 	mutex       sync.Mutex
+	status      *daemonStatus
+	config      Config
+	// requeue holds containers to be published again, i.e. under
+	// alternative names after a collision.
+	requeue *queue
+	owners  *hostnameOwners
 }
 
-func newEntryGroups(avahiServer *avahi.Server) *entryGroups {
+func newEntryGroups(avahiServer *avahi.Server, status *daemonStatus, config Config) *entryGroups {
 	return &entryGroups{
 		avahiServer: avahiServer,
-		groups:      make(map[string]*avahi.EntryGroup),
+		groups:      make(map[string]*entryGroup),
 		mutex:       sync.Mutex{},
+		status:      status,
+		config:      config,
+		requeue:     newQueue(),
+		owners:      newHostnameOwners(config.HostnamePolicy),
 	}
 }
 
-func (e *entryGroups) get(containerID string) (*avahi.EntryGroup, func(), error) {
+func (e *entryGroups) get(containerID string) (*entryGroup, func(), error) {
 	commit := func() {
 		defer e.mutex.Unlock()
 
@@ -44,15 +54,137 @@ func (e *entryGroups) get(containerID string) (*avahi.EntryGroup, func(), error)
 	log.Logf(log.PriDebug, "got lock for container ID: %s", containerID)
 
 	if _, ok := e.groups[containerID]; !ok {
-		entryGroup, err := e.avahiServer.EntryGroupNew()
+		avahiEntryGroup, err := e.avahiServer.EntryGroupNew()
 		if err != nil {
 			e.mutex.Unlock()
 
 			return nil, func() {}, fmt.Errorf("error creating new entry group: %w", err)
 		}
 
-		e.groups[containerID] = entryGroup
+		e.groups[containerID] = newEntryGroup(avahiEntryGroup)
+		e.updateStatus()
+
+		go e.watch(containerID, e.groups[containerID])
 	}
 
 	return e.groups[containerID], commit, nil
 }
+
+// remove frees the container's entry group and forgets about the
+// container.
+func (e *entryGroups) remove(containerID string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	entryGroup, ok := e.groups[containerID]
+	if !ok {
+		return
+	}
+
+	// Stop watching the state changes only after freeing the entry
+	// group so Avahi doesn't block dispatching a last state change.
+	e.avahiServer.EntryGroupFree(entryGroup.EntryGroup)
+	close(entryGroup.stop)
+	delete(e.groups, containerID)
+	e.updateStatus()
+
+	log.Logf(log.PriDebug, "freed entry group for container ID: %s", containerID)
+}
+
+// withdraw resets the entry groups of the containers until they are
+// published again. Must be called with the lock held, i.e. between
+// get() and its commit.
+func (e *entryGroups) withdraw(containerIDs []string) {
+	for _, containerID := range containerIDs {
+		entryGroup, ok := e.groups[containerID]
+		if !ok {
+			continue
+		}
+
+		empty, err := entryGroup.IsEmpty()
+		if err != nil {
+			log.Logf(log.PriErr, "checking whether Avahi entry group is empty: %v", err)
+		}
+
+		if empty {
+			continue
+		}
+
+		err = entryGroup.Reset()
+		if err != nil {
+			log.Logf(log.PriErr, "withdrawing Avahi entry group of container %s: %v", containerID, err)
+		}
+	}
+}
+
+// replaceServer replaces the Avahi server after avahi-daemon has been
+// restarted. The entry groups died with the old avahi-daemon so they
+// are dropped and must be rebuilt.
+//
+// The old server is closed along with its own connection to the bus.
+// Only then are the watchers of the old entry groups stopped so the
+// old server never blocks dispatching a last state change.
+func (e *entryGroups) replaceServer(avahiServer *avahi.Server) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.avahiServer != nil {
+		e.avahiServer.Close()
+	}
+
+	for _, entryGroup := range e.groups {
+		close(entryGroup.stop)
+	}
+
+	e.avahiServer = avahiServer
+	e.groups = make(map[string]*entryGroup)
+	e.updateStatus()
+}
+
+// close the current Avahi server withdrawing all entry groups.
+func (e *entryGroups) close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.avahiServer.Close()
+}
+
+// updateStatus reports the number of live entry groups. Must be
+// called with the lock held.
+func (e *entryGroups) updateStatus() {
+	err := e.status.setGroups(len(e.groups))
+	if err != nil {
+		log.Logf(log.PriErr, "notifying systemd about entry groups: %v", err)
+	}
+}
+
+// hostFQDN returns the host's own name as published by Avahi. Must be
+// called with the lock held, i.e. between get() and its commit.
+func (e *entryGroups) hostFQDN() (string, error) {
+	hostFQDN, err := e.avahiServer.GetHostNameFqdn()
+	if err != nil {
+		return "", fmt.Errorf("getting host name from Avahi: %w", err)
+	}
+
+	return hostFQDN, nil
+}
+
+// published returns the container IDs we have entry groups for and
+// whether each entry group is empty.
+func (e *entryGroups) published() map[string]bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	published := make(map[string]bool, len(e.groups))
+
+	for containerID, entryGroup := range e.groups {
+		empty, err := entryGroup.IsEmpty()
+		if err != nil {
+			log.Logf(log.PriErr, "checking whether Avahi entry group is empty: %v", err)
+		}
+
+		published[containerID] = empty
+	}
+
+	return published
+}
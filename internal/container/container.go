@@ -1,11 +1,17 @@
 package container
 
 import (
+	"cmp"
 	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
 	"honnef.co/go/netdb"
 	"ldddns.arnested.dk/internal/log"
 )
@@ -22,10 +28,32 @@ func (c Container) Name() string {
 
 // IPAddresses returns a slice of the IPv4 addresses of the container.
 func (c Container) IPAddresses() []string {
+	return c.NetworkIPAddresses(c.Networks())
+}
+
+// IPv6Addresses returns a slice of the global IPv6 addresses of the
+// container.
+func (c Container) IPv6Addresses() []string {
+	return c.NetworkIPv6Addresses(c.Networks())
+}
+
+// Networks returns the sorted names of the networks the container is
+// attached to.
+func (c Container) Networks() []string {
+	if c.NetworkSettings == nil {
+		return []string{}
+	}
+
+	return slices.Sorted(maps.Keys(c.NetworkSettings.Networks))
+}
+
+// NetworkIPAddresses returns a slice of the IPv4 addresses of the
+// container on the named networks in the order of the networks.
+func (c Container) NetworkIPAddresses(networks []string) []string {
 	ips := []string{}
 
-	for _, v := range c.NetworkSettings.Networks {
-		if v.IPAddress.IsValid() {
+	for _, name := range networks {
+		if v := c.network(name); v != nil && v.IPAddress.IsValid() {
 			ips = append(ips, v.IPAddress.String())
 		}
 	}
@@ -33,9 +61,72 @@ func (c Container) IPAddresses() []string {
 	return ips
 }
 
-// Services from a container.
-func (c Container) Services() map[string]uint16 {
-	services := map[string]uint16{}
+// NetworkIPv6Addresses returns a slice of the global IPv6 addresses of
+// the container on the named networks in the order of the networks.
+func (c Container) NetworkIPv6Addresses(networks []string) []string {
+	ips := []string{}
+
+	for _, name := range networks {
+		if v := c.network(name); v != nil && v.GlobalIPv6Address.IsValid() {
+			ips = append(ips, v.GlobalIPv6Address.String())
+		}
+	}
+
+	return ips
+}
+
+func (c Container) network(name string) *network.EndpointSettings {
+	if c.NetworkSettings == nil {
+		return nil
+	}
+
+	return c.NetworkSettings.Networks[name]
+}
+
+// serviceLabelPrefix is the prefix of labels declaring the DNS-SD
+// service types of a port, i.e. `ldddns.service.8080=_http._tcp`.
+const serviceLabelPrefix = "ldddns.service."
+
+// txtLabelPrefix is the prefix of labels declaring TXT records for the
+// services of a port, i.e. `ldddns.txt.8080.path=/admin`.
+const txtLabelPrefix = "ldddns.txt."
+
+// subtypeLabelPrefix is the prefix of labels declaring subtypes for the
+// services of a port, i.e. `ldddns.subtype.8080=_printer`.
+const subtypeLabelPrefix = "ldddns.subtype."
+
+// MaxTXTStringLength is the maximum length of a single TXT record
+// string (RFC 6763 section 6.1).
+const MaxTXTStringLength = 255
+
+// maxSubtypeLength is the maximum length of a subtype label.
+const maxSubtypeLength = 63
+
+// serviceTypeRegExp matches DNS-SD service types (RFC 6763 section 7).
+var serviceTypeRegExp = regexp.MustCompile(`^_[A-Za-z0-9-]{1,15}\._(tcp|udp)$`)
+
+// Service is a DNS-SD service of a container on a port.
+type Service struct {
+	// Type is the service type, i.e. `_http._tcp`.
+	Type string
+	Port uint16
+}
+
+// Proto returns the transport protocol of the service: `tcp` or `udp`.
+func (s Service) Proto() string {
+	if strings.HasSuffix(s.Type, "._udp") {
+		return "udp"
+	}
+
+	return "tcp"
+}
+
+// Services from a container sorted by port and service type. Service
+// types declared with labels for a port and protocol take priority
+// over the service looked up in `/etc/services`.
+func (c Container) Services() []Service {
+	labelled := c.labelledServices()
+	services := slices.Clone(labelled)
 
 	for portProto := range c.NetworkSettings.Ports {
 		port, protoName, found := strings.Cut(portProto.String(), "/")
@@ -61,18 +152,191 @@ func (c Container) Services() map[string]uint16 {
 			continue
 		}
 
+		if slices.ContainsFunc(labelled, func(s Service) bool {
+			return s.Port == uint16(portNumber) && s.Proto() == protoName
+		}) {
+			continue
+		}
+
 		service := netdb.GetServByPort(int(portNumber), proto)
 
 		if service == nil || proto == nil {
 			continue
 		}
 
-		services[fmt.Sprintf("_%s._%s", service.Name, proto.Name)] = uint16(portNumber)
+		services = append(services, Service{
+			Type: fmt.Sprintf("_%s._%s", service.Name, proto.Name),
+			Port: uint16(portNumber),
+		})
+	}
+
+	slices.SortFunc(services, func(a, b Service) int {
+		return cmp.Or(cmp.Compare(a.Port, b.Port), strings.Compare(a.Type, b.Type))
+	})
+
+	return slices.Compact(services)
+}
+
+// labelledServices returns the services declared with labels. Several
+// service types can be declared for a port separated by commas.
+func (c Container) labelledServices() []Service {
+	services := []Service{}
+
+	for label, value := range c.Config.Labels {
+		port, found := strings.CutPrefix(label, serviceLabelPrefix)
+		if !found {
+			continue
+		}
+
+		portNumber, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			log.Logf(log.PriWarning, "Ignoring label %q: invalid port number", label)
+
+			continue
+		}
+
+		for serviceType := range strings.SplitSeq(value, ",") {
+			serviceType = strings.TrimSpace(serviceType)
+
+			if !serviceTypeRegExp.MatchString(serviceType) {
+				log.Logf(log.PriWarning, "Ignoring invalid service type %q in label %q", serviceType, label)
+
+				continue
+			}
+
+			services = append(services, Service{Type: serviceType, Port: uint16(portNumber)})
+		}
 	}
 
 	return services
 }
 
+// ServiceTXT returns the TXT records declared with labels for the
+// services on a port sorted by key. Invalid records are logged and
+// left out.
+func (c Container) ServiceTXT(port uint16) [][]byte {
+	prefix := fmt.Sprintf("%s%d.", txtLabelPrefix, port)
+	records := [][]byte{}
+
+	for _, label := range slices.Sorted(maps.Keys(c.Config.Labels)) {
+		key, found := strings.CutPrefix(label, prefix)
+		if !found {
+			continue
+		}
+
+		if !validTXTKey(key) {
+			log.Logf(log.PriWarning, "Ignoring label %q: invalid TXT record key %q", label, key)
+
+			continue
+		}
+
+		record := key + "=" + c.Config.Labels[label]
+		if len(record) > MaxTXTStringLength {
+			log.Logf(
+				log.PriWarning,
+				"Ignoring label %q: the TXT record is %d bytes, longer than %d bytes",
+				label,
+				len(record),
+				MaxTXTStringLength,
+			)
+
+			continue
+		}
+
+		records = append(records, []byte(record))
+	}
+
+	return records
+}
+
+// validTXTKey tells whether the key is a valid TXT record key: at
+// least one printable US-ASCII character except `=` (RFC 6763 section
+// 6.4).
+func validTXTKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, r := range key {
+		if r < 0x20 || r > 0x7e || r == '=' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ServiceSubtypes returns the subtypes declared with labels for a
+// service type on a port, i.e. `_printer._sub._http._tcp`. Several
+// subtypes can be declared separated by commas, either as the subtype
+// alone (`_printer`) or in full (`_printer._sub._http._tcp`). Subtypes
+// of other service types on the port are left out.
+func (c Container) ServiceSubtypes(serviceType string, port uint16) []string {
+	label := fmt.Sprintf("%s%d", subtypeLabelPrefix, port)
+	subtypes := []string{}
+
+	value, ok := c.Config.Labels[label]
+	if !ok {
+		return subtypes
+	}
+
+	for subtype := range strings.SplitSeq(value, ",") {
+		subtype = strings.TrimSpace(subtype)
+
+		if name, ofServiceType, found := strings.Cut(subtype, "._sub."); found {
+			if ofServiceType == serviceType && validSubtype(name) {
+				subtypes = append(subtypes, subtype)
+			}
+
+			continue
+		}
+
+		if !validSubtype(subtype) {
+			log.Logf(log.PriWarning, "Ignoring invalid subtype %q in label %q", subtype, label)
+
+			continue
+		}
+
+		subtypes = append(subtypes, subtype+"._sub."+serviceType)
+	}
+
+	return subtypes
+}
+
+// validSubtype tells whether the subtype is a single DNS label.
+func validSubtype(subtype string) bool {
+	return subtype != "" && len(subtype) <= maxSubtypeLength && !strings.Contains(subtype, ".")
+}
+
+// HostPort returns the port on the host that the port of a service is
+// published on. Bindings to loopback addresses are left out as they
+// cannot be reached from the network.
+func (c Container) HostPort(service Service) (uint16, bool) {
+	if c.NetworkSettings == nil {
+		return 0, false
+	}
+
+	portProto, err := network.ParsePort(fmt.Sprintf("%d/%s", service.Port, service.Proto()))
+	if err != nil {
+		return 0, false
+	}
+
+	for _, binding := range c.NetworkSettings.Ports[portProto] {
+		if binding.HostIP.IsValid() && binding.HostIP.IsLoopback() {
+			continue
+		}
+
+		hostPort, err := strconv.ParseUint(binding.HostPort, 10, 16)
+		if err != nil || hostPort == 0 {
+			continue
+		}
+
+		return uint16(hostPort), true
+	}
+
+	return 0, false
+}
+
 // HostnamesFromEnv a container, return them as string slices.
 func (c Container) HostnamesFromEnv(envName string) []string {
 	prefix := envName + "="
@@ -87,6 +351,53 @@ func (c Container) HostnamesFromEnv(envName string) []string {
 	return []string{}
 }
 
+// HostnamesFromCompose returns the hostnames of a Docker Compose
+// container without a domain: `<service>-<project>` and the basename of
+// the project's working directory as an alias for the project.
+func (c Container) HostnamesFromCompose() []string {
+	hostnames := []string{}
+
+	service := c.Config.Labels["com.docker.compose.service"]
+	project := c.Config.Labels["com.docker.compose.project"]
+
+	if service != "" && project != "" {
+		hostnames = append(hostnames, service+"-"+project)
+	}
+
+	if workingDir := c.Config.Labels["com.docker.compose.project.working_dir"]; workingDir != "" {
+		if alias := path.Base(workingDir); alias != "/" && alias != "." {
+			hostnames = append(hostnames, alias)
+		}
+	}
+
+	return hostnames
+}
+
+// shortIDLength is the length of the hostnames Docker generates from
+// the container ID.
+const shortIDLength = 12
+
+// HostnameFromConfig returns the hostname of the container, joined
+// with its domain name if it has one, i.e. `db.acme.test` from
+// `--hostname db --domainname acme.test`. It returns false if the
+// container has no hostname or the hostname is generated from the
+// container ID.
+func (c Container) HostnameFromConfig() (string, bool) {
+	if c.Config.Hostname == "" {
+		return "", false
+	}
+
+	if len(c.ID) >= shortIDLength && c.Config.Hostname == c.ID[:shortIDLength] {
+		return "", false
+	}
+
+	if c.Config.Domainname == "" {
+		return c.Config.Hostname, true
+	}
+
+	return c.Config.Hostname + "." + c.Config.Domainname, true
+}
+
 // HostnamesFromLabel a container, return them as string slices.
 func (c Container) HostnamesFromLabel(label string) []string {
 	if s, ok := c.Config.Labels[label]; ok {
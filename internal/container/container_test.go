@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/moby/moby/api/types/container"
@@ -90,22 +92,10 @@ func TestServices(t *testing.T) {
 		t.Fatalf("getting test data: %s", err)
 	}
 
-	expectedService := "_http._tcp"
-	expectedPort := uint16(80)
+	expected := internalContainer.Service{Type: "_http._tcp", Port: 80}
 
-	services := data.Services()
-
-	if _, ok := services[expectedService]; !ok {
-		t.Errorf("Expected a %q service, none found", expectedService)
-	}
-
-	if _, ok := services[expectedService]; ok && services[expectedService] != expectedPort {
-		t.Errorf(
-			"Expected %q service to be on port %d, got port %d",
-			expectedService,
-			expectedPort,
-			services[expectedService],
-		)
+	if services := data.Services(); !slices.Contains(services, expected) {
+		t.Errorf("Expected a %q service on port %d, got %v", expected.Type, expected.Port, services)
 	}
 }
 
@@ -270,3 +260,257 @@ func TestHostnamesFromLabel(t *testing.T) {
 		t.Errorf("Didn't expected any hostnames from `NON_EXISTING_ENV_VAR`, got %q.", noHostnames)
 	}
 }
+
+func TestHostnameFromConfig(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	if hostname, ok := data.HostnameFromConfig(); ok {
+		t.Errorf("Didn't expect the generated hostname to be used, got %q", hostname)
+	}
+
+	data.Config.Hostname = "db"
+	data.Config.Domainname = "acme.test"
+
+	if hostname, ok := data.HostnameFromConfig(); !ok || hostname != "db.acme.test" {
+		t.Errorf("Expected hostname %q, got %q", "db.acme.test", hostname)
+	}
+}
+
+func TestHostnamesFromCompose(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	expected := []string{"client-foobar", "foobar"}
+
+	if hostnames := data.HostnamesFromCompose(); !slices.Equal(hostnames, expected) {
+		t.Errorf("Expected hostnames %q, got %q", expected, hostnames)
+	}
+
+	data.Config.Labels = map[string]string{"com.docker.compose.service": "client"}
+
+	if hostnames := data.HostnamesFromCompose(); len(hostnames) != 0 {
+		t.Errorf("Didn't expect any hostnames without a project, got %q", hostnames)
+	}
+}
+
+func TestIPv6Addresses(t *testing.T) {
+	t.Parallel()
+
+	jsonData := `{
+		"Id": "test",
+		"Name": "/test",
+		"NetworkSettings": {
+			"Ports": {},
+			"Networks": {
+				"dualstack": {
+					"IPAddress": "172.18.0.4",
+					"GlobalIPv6Address": "fd00:dead:beef::4"
+				}
+			}
+		},
+		"Config": {
+			"Env": [],
+			"Labels": {}
+		}
+	}`
+
+	var inspectResponse container.InspectResponse
+
+	err := json.Unmarshal([]byte(jsonData), &inspectResponse)
+	if err != nil {
+		t.Fatalf("failed to unmarshal test data: %v", err)
+	}
+
+	c := internalContainer.Container{InspectResponse: inspectResponse}
+
+	expected := []string{"fd00:dead:beef::4"}
+	ips := c.IPv6Addresses()
+
+	if len(ips) != len(expected) {
+		t.Fatalf("Expected %d IPv6 address, got %d IPv6 addresses.", len(expected), len(ips))
+	}
+
+	if ips[0] != expected[0] {
+		t.Errorf("Expected first IPv6 address to be %q, got %q.", expected[0], ips[0])
+	}
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	if ips := data.IPv6Addresses(); len(ips) != 0 {
+		t.Errorf("Expected no IPv6 addresses for IPv4 only container, got %q", ips)
+	}
+}
+
+func TestNetworkIPAddresses(t *testing.T) {
+	t.Parallel()
+
+	jsonData := `{
+		"Id": "test",
+		"Name": "/test",
+		"NetworkSettings": {
+			"Ports": {},
+			"Networks": {
+				"frontend": {
+					"IPAddress": "172.18.0.4",
+					"GlobalIPv6Address": "fd00:dead:beef::4"
+				},
+				"backend": {
+					"IPAddress": "172.19.0.7"
+				}
+			}
+		},
+		"Config": {
+			"Env": [],
+			"Labels": {}
+		}
+	}`
+
+	var inspectResponse container.InspectResponse
+
+	err := json.Unmarshal([]byte(jsonData), &inspectResponse)
+	if err != nil {
+		t.Fatalf("failed to unmarshal test data: %v", err)
+	}
+
+	c := internalContainer.Container{InspectResponse: inspectResponse}
+
+	if networks := c.Networks(); !slices.Equal(networks, []string{"backend", "frontend"}) {
+		t.Errorf("Expected sorted networks, got %q", networks)
+	}
+
+	expected := []string{"172.18.0.4", "172.19.0.7"}
+
+	if ips := c.NetworkIPAddresses([]string{"frontend", "backend"}); !slices.Equal(ips, expected) {
+		t.Errorf("Expected IP addresses in network order, got %q", ips)
+	}
+
+	if ips := c.NetworkIPv6Addresses([]string{"backend", "unknown"}); len(ips) != 0 {
+		t.Errorf("Expected no IPv6 addresses on backend, got %q", ips)
+	}
+}
+
+func TestServicesFromLabels(t *testing.T) {
+	t.Parallel()
+
+	c := createTestContainerWithPorts(t, `{
+		"80/tcp": [{"HostIp": "", "HostPort": ""}],
+		"8080/tcp": [{"HostIp": "", "HostPort": ""}],
+		"22/tcp": [{"HostIp": "", "HostPort": ""}]
+	}`)
+	c.Config.Labels = map[string]string{
+		"ldddns.service.22":    "_sftp-ssh._tcp, _ssh._tcp",
+		"ldddns.service.8080":  "_http._tcp",
+		"ldddns.service.8081":  "_http._tcp",
+		"ldddns.service.9000":  "_myproto._tcp",
+		"ldddns.service.bogus": "_http._tcp",
+		"ldddns.service.9001":  "not a service type",
+	}
+
+	// The label on 8080 doesn't replace the service found for port
+	// 80, and several ports can have the same service type.
+	expected := []internalContainer.Service{
+		{Type: "_sftp-ssh._tcp", Port: 22},
+		{Type: "_ssh._tcp", Port: 22},
+		{Type: "_http._tcp", Port: 80},
+		{Type: "_http._tcp", Port: 8080},
+		{Type: "_http._tcp", Port: 8081},
+		{Type: "_myproto._tcp", Port: 9000},
+	}
+
+	if services := c.Services(); !slices.Equal(services, expected) {
+		t.Errorf("Expected services %v, got %v", expected, services)
+	}
+}
+
+func TestServiceTXT(t *testing.T) {
+	t.Parallel()
+
+	c := createTestContainerWithPorts(t, `{}`)
+	c.Config.Labels = map[string]string{
+		"ldddns.txt.8080.path":    "/admin",
+		"ldddns.txt.8080.empty":   "",
+		"ldddns.txt.8080.a=b":     "invalid key",
+		"ldddns.txt.8080.long":    strings.Repeat("x", 251),
+		"ldddns.txt.9000.version": "2",
+	}
+
+	records := []string{}
+	for _, record := range c.ServiceTXT(8080) {
+		records = append(records, string(record))
+	}
+
+	expected := []string{"empty=", "path=/admin"}
+	if !slices.Equal(records, expected) {
+		t.Errorf("Expected TXT records %q, got %q", expected, records)
+	}
+}
+
+func TestServiceSubtypes(t *testing.T) {
+	t.Parallel()
+
+	c := createTestContainerWithPorts(t, `{}`)
+	c.Config.Labels = map[string]string{
+		"ldddns.subtype.631": "_printer, _universal._sub._ipp._tcp, _scanner._sub._uscan._tcp, bad.label",
+	}
+
+	expected := []string{"_printer._sub._ipp._tcp", "_universal._sub._ipp._tcp"}
+	if subtypes := c.ServiceSubtypes("_ipp._tcp", 631); !slices.Equal(subtypes, expected) {
+		t.Errorf("Expected subtypes %q, got %q", expected, subtypes)
+	}
+
+	if subtypes := c.ServiceSubtypes("_ipp._tcp", 80); len(subtypes) != 0 {
+		t.Errorf("Expected no subtypes on port 80, got %q", subtypes)
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	t.Parallel()
+
+	c := createTestContainerWithPorts(t, `{
+		"80/tcp": [{"HostIp": "0.0.0.0", "HostPort": "8080"}, {"HostIp": "::", "HostPort": "8080"}],
+		"443/tcp": [{"HostIp": "127.0.0.1", "HostPort": "8443"}],
+		"53/udp": [{"HostIp": "", "HostPort": "5353"}],
+		"22/tcp": []
+	}`)
+
+	tests := []struct {
+		serviceType string
+		port        uint16
+		hostPort    uint16
+		found       bool
+	}{
+		{"_http._tcp", 80, 8080, true},
+		{"_https._tcp", 443, 0, false},
+		{"_domain._udp", 53, 5353, true},
+		{"_domain._tcp", 53, 0, false},
+		{"_ssh._tcp", 22, 0, false},
+	}
+
+	for _, testCase := range tests {
+		hostPort, found := c.HostPort(internalContainer.Service{Type: testCase.serviceType, Port: testCase.port})
+
+		if hostPort != testCase.hostPort || found != testCase.found {
+			t.Errorf(
+				"Expected host port (%d, %v) for %s on %d, got (%d, %v)",
+				testCase.hostPort,
+				testCase.found,
+				testCase.serviceType,
+				testCase.port,
+				hostPort,
+				found,
+			)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package hostname
 
 import (
 	"regexp"
+	"strings"
 
 	"golang.org/x/net/idna"
 	"golang.org/x/net/publicsuffix"
@@ -9,35 +10,160 @@ import (
 	"ldddns.arnested.dk/internal/log"
 )
 
-const tld = ".local"
-
 // Hostnames returns a slice of the hostnames we should use for the
-// container.
-func Hostnames(containerInfo container.Container, hostnameLookup []string) ([]string, error) {
+// container. The hostnames are rewritten by the rewrite rules and then
+// to be on the `tld` top-level domain.
+func Hostnames(
+	containerInfo container.Container,
+	hostnameLookup Lookups,
+	rules RewriteRules,
+	tld string,
+) ([]string, error) {
+	hostnames := lookupHostnames(containerInfo, hostnameLookup, rules, tld)
+
+	for i, hostname := range hostnames {
+		hostnames[i] = RewriteHostname(hostname, tld)
+	}
+
+	return removeDuplicates(hostnames), nil
+}
+
+// FQDNs returns a slice of the fully qualified domain names we should
+// use for the container. Contrary to Hostnames() the names keep all
+// their labels and are only moved to the `domain` domain.
+func FQDNs(
+	containerInfo container.Container,
+	hostnameLookup Lookups,
+	rules RewriteRules,
+	domain string,
+) ([]string, error) {
+	hostnames := lookupHostnames(containerInfo, hostnameLookup, rules, domain)
+
+	for i, hostname := range hostnames {
+		hostnames[i] = RewriteFQDN(hostname, domain)
+	}
+
+	return removeDuplicates(hostnames), nil
+}
+
+// lookupHostnames returns the hostnames found by the hostname lookups
+// rewritten by the rewrite rules. The rules see the names as they are
+// looked up: names without a domain, i.e. the container name, only
+// get the `tld` top-level domain afterwards. Names rewritten to
+// nothing are left out.
+func lookupHostnames(
+	containerInfo container.Container,
+	hostnameLookup Lookups,
+	rules RewriteRules,
+	tld string,
+) []string {
 	var hostnames []string
 
 	for _, lookup := range hostnameLookup {
-		switch {
-		case lookup == "containerName":
-			hostnames = append(hostnames, containerInfo.Name()+tld)
+		names, local := lookupNames(containerInfo, lookup)
+
+		for _, name := range names {
+			name = rules.Apply(name)
+			if name == "" {
+				continue
+			}
 
-		case lookup[0:4] == "env:":
-			hostnames = append(hostnames, containerInfo.HostnamesFromEnv(lookup[4:])...)
+			if local {
+				name += "." + tld
+			}
 
-		case lookup[0:6] == "label:":
-			hostnames = append(hostnames, containerInfo.HostnamesFromLabel(lookup[6:])...)
+			hostnames = append(hostnames, name)
 		}
 	}
 
-	for i, hostname := range hostnames {
-		hostnames[i] = RewriteHostname(hostname)
+	return hostnames
+}
+
+// lookupNames returns the unprocessed names found by a hostname
+// lookup and whether they are without a domain and belong on the
+// top-level domain.
+func lookupNames(containerInfo container.Container, lookup Lookup) ([]string, bool) {
+	switch source := lookup.source; {
+	case source == "containerName":
+		return []string{containerInfo.Name()}, true
+
+	case source == "containerHostname":
+		hostname, ok := containerInfo.HostnameFromConfig()
+		if !ok {
+			return []string{}, false
+		}
+
+		// A hostname without a domain name is on the top-level
+		// domain like the container name.
+		return []string{hostname}, !strings.Contains(hostname, ".")
+
+	case source == "compose":
+		return containerInfo.HostnamesFromCompose(), true
+
+	case source == "traefik":
+		return hostnamesFromTraefik(containerInfo), false
+
+	case strings.HasPrefix(source, "env:"):
+		return containerInfo.HostnamesFromEnv(source[4:]), false
+
+	case strings.HasPrefix(source, "label:"):
+		return containerInfo.HostnamesFromLabel(source[6:]), false
+
+	case strings.HasPrefix(source, "template:"):
+		return hostnamesFromTemplate(containerInfo, lookup), false
+
+	default:
+		return []string{}, false
+	}
+}
+
+// hostnamesFromTemplate returns the hostnames from executing the
+// parsed Go template against the container. Several hostnames can be
+// separated by spaces or commas. Missing map keys, i.e. labels, are
+// empty. A failing template gives no hostnames and is only logged the
+// first time for each container.
+func hostnamesFromTemplate(containerInfo container.Container, lookup Lookup) []string {
+	var output strings.Builder
+
+	err := lookup.template.Execute(&output, containerInfo)
+	if err != nil {
+		logOnce(
+			log.PriErr,
+			containerInfo.ID,
+			lookup.source,
+			"Hostname template %q failed for container %s: %v",
+			lookup.source[len("template:"):],
+			containerInfo.ID,
+			err,
+		)
+
+		return []string{}
 	}
 
-	return removeDuplicates(hostnames), nil
+	return strings.FieldsFunc(output.String(), func(r rune) bool { return r == ' ' || r == ',' })
+}
+
+// ValidLookup tells whether `lookup` is a known hostname lookup.
+func ValidLookup(lookup string) bool {
+	_, err := ParseLookup(lookup)
+
+	return err == nil
+}
+
+// RewriteHostname will make `hostname` suitable for dns-sd on the
+// `tld` top-level domain.
+func RewriteHostname(hostname string, tld string) string {
+	return rewrite(hostname, tld, false)
 }
 
-// RewriteHostname will make `hostname` suitable for dns-sd.
-func RewriteHostname(hostname string) string {
+// RewriteFQDN will make `hostname` a valid domain name on the
+// `domain` domain while keeping its labels. I.e. `api.shop.com`
+// will be rewritten to `api.shop.test` on the `test` domain.
+func RewriteFQDN(hostname string, domain string) string {
+	return rewrite(hostname, domain, true)
+}
+
+func rewrite(hostname string, tld string, keepLabels bool) string {
 	profile := idna.New(
 		idna.BidiRule(),
 		idna.MapForLookup(),
@@ -54,21 +180,32 @@ func RewriteHostname(hostname string) string {
 	//nolint:errcheck
 	unicodeHostname, _ := profile.ToUnicode(hostname)
 
-	suffix, _ := publicsuffix.PublicSuffix(unicodeHostname)
+	// The top-level domain might not be a public suffix (or might
+	// span several labels) so we strip it before falling back to
+	// stripping the public suffix.
+	basename, found := strings.CutSuffix(unicodeHostname, "."+tld)
+	if !found {
+		suffix, _ := publicsuffix.PublicSuffix(unicodeHostname)
 
-	suffixRegExp := regexp.MustCompile(`\.` + regexp.QuoteMeta(suffix) + `$`)
-	basename := suffixRegExp.ReplaceAllString(unicodeHostname, "")
+		suffixRegExp := regexp.MustCompile(`\.` + regexp.QuoteMeta(suffix) + `$`)
+		basename = suffixRegExp.ReplaceAllString(unicodeHostname, "")
+	}
 
-	suffixRegExp = regexp.MustCompile(`[^\pL\d-]`)
-	basename = suffixRegExp.ReplaceAllString(basename, "-")
+	if keepLabels {
+		labels := []string{}
 
-	suffixRegExp = regexp.MustCompile(`--+`)
-	basename = suffixRegExp.ReplaceAllString(basename, "-")
+		for label := range strings.SplitSeq(basename, ".") {
+			if label = sanitize(label); label != "" {
+				labels = append(labels, label)
+			}
+		}
 
-	suffixRegExp = regexp.MustCompile(`(^-+|-+$)`)
-	basename = suffixRegExp.ReplaceAllString(basename, "")
+		basename = strings.Join(labels, ".")
+	} else {
+		basename = sanitize(basename)
+	}
 
-	sanitizedHostname := basename + tld
+	sanitizedHostname := basename + "." + tld
 
 	sanitizedHostname, err := profile.ToASCII(sanitizedHostname)
 	if err != nil {
@@ -82,6 +219,20 @@ func RewriteHostname(hostname string) string {
 	return sanitizedHostname
 }
 
+// sanitize replaces anything but letters, digits and hyphens with
+// single hyphens.
+func sanitize(basename string) string {
+	suffixRegExp := regexp.MustCompile(`[^\pL\d-]`)
+	basename = suffixRegExp.ReplaceAllString(basename, "-")
+
+	suffixRegExp = regexp.MustCompile(`--+`)
+	basename = suffixRegExp.ReplaceAllString(basename, "-")
+
+	suffixRegExp = regexp.MustCompile(`(^-+|-+$)`)
+
+	return suffixRegExp.ReplaceAllString(basename, "")
+}
+
 // removeDuplicates and keep the order.
 func removeDuplicates(a []string) []string {
 	result := []string{}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"testing"
 
 	"github.com/moby/moby/api/types/container"
@@ -47,6 +48,17 @@ func containerData() (*internalContainer.Container, error) {
 	return &data, nil
 }
 
+func parseLookups(t *testing.T, lookups ...string) hostname.Lookups {
+	t.Helper()
+
+	parsed, err := hostname.ParseLookups(lookups)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing hostname lookups: %s", err)
+	}
+
+	return parsed
+}
+
 //nolint:cyclop
 func TestHostnames(t *testing.T) {
 	t.Parallel()
@@ -56,12 +68,12 @@ func TestHostnames(t *testing.T) {
 		t.Fatalf("getting test data: %s", err)
 	}
 
-	hostnames, err := hostname.Hostnames(*data, []string{
+	hostnames, err := hostname.Hostnames(*data, parseLookups(t,
 		"env:VIRTUAL_HOST",
 		"containerName",
 		"env:VIRTUAL_HOST", // we repeat VIRTUAL_HOST to check if we remove duplicates
 		"label:com.docker.compose.service",
-	})
+	), nil, "local")
 	if err != nil {
 		t.Fatalf("Unexpected error getting hostnames: %s", err)
 	}
@@ -89,6 +101,85 @@ func TestHostnames(t *testing.T) {
 	}
 }
 
+func TestHostnamesContainerHostname(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	tests := []struct {
+		hostname   string
+		domainname string
+		expected   []string
+	}{
+		{"67804081963d", "", []string{}},
+		{"", "acme.test", []string{}},
+		{"db", "", []string{"db.local"}},
+		{"db", "acme.test", []string{"db-acme.local"}},
+	}
+
+	for _, test := range tests {
+		data.Config.Hostname = test.hostname
+		data.Config.Domainname = test.domainname
+
+		hostnames, err := hostname.Hostnames(*data, parseLookups(t, "containerHostname"), nil, "local")
+		if err != nil {
+			t.Fatalf("Unexpected error getting hostnames: %s", err)
+		}
+
+		if !slices.Equal(hostnames, test.expected) {
+			t.Errorf("Expected hostnames %q for %q, got %q", test.expected, test.hostname, hostnames)
+		}
+	}
+}
+
+func TestHostnamesCompose(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	hostnames, err := hostname.Hostnames(*data, parseLookups(t, "compose"), nil, "local")
+	if err != nil {
+		t.Fatalf("Unexpected error getting hostnames: %s", err)
+	}
+
+	expected := []string{"client-foobar.local", "foobar.local"}
+
+	if !slices.Equal(hostnames, expected) {
+		t.Errorf("Expected hostnames %q, got %q", expected, hostnames)
+	}
+}
+
+func TestHostnamesTemplate(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	hostnames, err := hostname.Hostnames(*data, parseLookups(t,
+		`template:{{index .Config.Labels "com.docker.compose.service"}}-{{.Name}}`,
+		"template:{{.Config.Labels.missing}}",
+		"template:{{.Missing}}",
+		"containerName",
+	), nil, "local")
+	if err != nil {
+		t.Fatalf("Unexpected error getting hostnames: %s", err)
+	}
+
+	expected := []string{"client-foobar-client-1.local", "foobar-client-1.local"}
+
+	if !slices.Equal(hostnames, expected) {
+		t.Errorf("Expected hostnames %q, got %q", expected, hostnames)
+	}
+}
+
 func TestRewriteHostname(t *testing.T) {
 	t.Parallel()
 
@@ -111,13 +202,86 @@ func TestRewriteHostname(t *testing.T) {
 		t.Run(tt.in, func(t *testing.T) {
 			t.Parallel()
 
-			if s := hostname.RewriteHostname(tt.in); s != tt.out {
+			if s := hostname.RewriteHostname(tt.in, "local"); s != tt.out {
 				t.Errorf("got %q from %q, want %q", s, tt.in, tt.out)
 			}
 		})
 	}
 }
 
+func TestRewriteHostnameTLD(t *testing.T) {
+	t.Parallel()
+
+	testdata := []struct {
+		in  string
+		tld string
+		out string
+	}{
+		{"example.com", "test", "example.test"},
+		{"example.test", "test", "example.test"},
+		{"foo_bar", "internal", "foo-bar.internal"},
+		{"foo.dev.lan", "dev.lan", "foo.dev.lan"},
+		{"foo.example.com", "dev.lan", "foo-example.dev.lan"},
+		{"example.local", "test", "example.test"},
+	}
+
+	for _, tt := range testdata {
+		t.Run(tt.in+" on "+tt.tld, func(t *testing.T) {
+			t.Parallel()
+
+			if s := hostname.RewriteHostname(tt.in, tt.tld); s != tt.out {
+				t.Errorf("got %q from %q, want %q", s, tt.in, tt.out)
+			}
+		})
+	}
+}
+
+func TestRewriteFQDN(t *testing.T) {
+	t.Parallel()
+
+	testdata := []struct {
+		in  string
+		out string
+	}{
+		{"api.shop.test", "api.shop.test"},
+		{"api.shop.com", "api.shop.test"},
+		{"api.shop.local", "api.shop.test"},
+		{"foo_bar.example.com", "foo-bar.example.test"},
+		{"foo..bar.com", "foo.bar.test"},
+		{"blåbær.grød.com", "xn--blbr-roah.xn--grd-1na.test"},
+	}
+
+	for _, tt := range testdata {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+
+			if s := hostname.RewriteFQDN(tt.in, "test"); s != tt.out {
+				t.Errorf("got %q from %q, want %q", s, tt.in, tt.out)
+			}
+		})
+	}
+}
+
+func TestFQDNs(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	fqdns, err := hostname.FQDNs(*data, parseLookups(t, "env:VIRTUAL_HOST", "containerName"), nil, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error getting FQDNs: %s", err)
+	}
+
+	expected := []string{"foobar.test", "baz.test", "foobar-client-1.test"}
+
+	if !slices.Equal(fqdns, expected) {
+		t.Errorf("Expected FQDNs %q, got %q", expected, fqdns)
+	}
+}
+
 func FuzzRewriteHostname(f *testing.F) {
 	f.Add("example.com")
 	f.Add("example87.com")
@@ -130,6 +294,32 @@ func FuzzRewriteHostname(f *testing.F) {
 	f.Add("xn--blbrgrd-fxak7p.local")
 
 	f.Fuzz(func(_ *testing.T, a string) {
-		hostname.RewriteHostname(a)
+		hostname.RewriteHostname(a, "local")
 	})
 }
+
+func TestValidLookup(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"containerName":      true,
+		"containerHostname":  true,
+		"compose":            true,
+		"traefik":            true,
+		"template:{{.Name}}": true,
+		"template:{{.Name":   false,
+		"template:":          false,
+		"env:VIRTUAL_HOST":   true,
+		"label:foo":          true,
+		"env:":               false,
+		"label":              false,
+		"foo":                false,
+		"":                   false,
+	}
+
+	for lookup, expected := range tests {
+		if valid := hostname.ValidLookup(lookup); valid != expected {
+			t.Errorf("Expected validity of %q to be %v, got %v", lookup, expected, valid)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package hostname
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+
+	"ldddns.arnested.dk/internal/log"
+)
+
+var errUnknownLookup = errors.New("unknown hostname lookup")
+
+// Lookup is a hostname lookup, i.e. `env:VIRTUAL_HOST`. The template of
+// a `template:` lookup is parsed along with the lookup.
+type Lookup struct {
+	source   string
+	template *template.Template
+}
+
+// ParseLookup parses a hostname lookup.
+func ParseLookup(lookup string) (Lookup, error) {
+	parsed := Lookup{source: lookup, template: nil}
+
+	switch {
+	case lookup == "containerName", lookup == "containerHostname", lookup == "compose", lookup == "traefik":
+	case strings.HasPrefix(lookup, "env:") && len(lookup) > len("env:"):
+	case strings.HasPrefix(lookup, "label:") && len(lookup) > len("label:"):
+	case strings.HasPrefix(lookup, "template:") && len(lookup) > len("template:"):
+		tmpl, err := template.New("hostname").Option("missingkey=zero").Parse(lookup[len("template:"):])
+		if err != nil {
+			return Lookup{}, fmt.Errorf("parsing hostname lookup %q: %w", lookup, err)
+		}
+
+		parsed.template = tmpl
+	default:
+		return Lookup{}, fmt.Errorf("%w: %q", errUnknownLookup, lookup)
+	}
+
+	return parsed, nil
+}
+
+// String returns the lookup as it was configured.
+func (l Lookup) String() string {
+	return l.source
+}
+
+// MarshalText returns the lookup as it was configured.
+func (l Lookup) MarshalText() ([]byte, error) {
+	return []byte(l.source), nil
+}
+
+// Lookups are hostname lookups in the order the hostnames are looked up.
+type Lookups []Lookup
+
+// ParseLookups parses hostname lookups.
+func ParseLookups(lookups []string) (Lookups, error) {
+	parsed := make(Lookups, 0, len(lookups))
+
+	for _, lookup := range lookups {
+		parsedLookup, err := ParseLookup(lookup)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, parsedLookup)
+	}
+
+	return parsed, nil
+}
+
+// Decode parses hostname lookups separated by commas, i.e.
+// `env:VIRTUAL_HOST,containerName`.
+func (l *Lookups) Decode(value string) error {
+	lookups := []string{}
+
+	for lookup := range strings.SplitSeq(value, ",") {
+		if lookup = strings.TrimSpace(lookup); lookup != "" {
+			lookups = append(lookups, lookup)
+		}
+	}
+
+	parsed, err := ParseLookups(lookups)
+	if err != nil {
+		return err
+	}
+
+	*l = parsed
+
+	return nil
+}
+
+// problems are the problems already logged for each container so they
+// are only logged once per container.
+//
+//nolint:gochecknoglobals
+var problems = struct {
+	logged map[string]map[string]bool
+	mutex  sync.Mutex
+}{
+	logged: make(map[string]map[string]bool),
+	mutex:  sync.Mutex{},
+}
+
+// logOnce logs a problem with a container unless it has already been
+// logged for the container.
+func logOnce(priority log.Priority, containerID string, problem string, format string, args ...any) {
+	problems.mutex.Lock()
+	defer problems.mutex.Unlock()
+
+	if problems.logged[containerID][problem] {
+		return
+	}
+
+	if problems.logged[containerID] == nil {
+		problems.logged[containerID] = make(map[string]bool)
+	}
+
+	problems.logged[containerID][problem] = true
+
+	log.Logf(priority, format, args...)
+}
+
+// Forget the problems logged for a container. Call it when the
+// container is removed.
+func Forget(containerID string) {
+	problems.mutex.Lock()
+	defer problems.mutex.Unlock()
+
+	delete(problems.logged, containerID)
+}
@@ -0,0 +1,73 @@
+package hostname_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"ldddns.arnested.dk/internal/hostname"
+)
+
+func TestLookupsDecode(t *testing.T) {
+	t.Parallel()
+
+	var lookups hostname.Lookups
+
+	err := lookups.Decode(" env:VIRTUAL_HOST, template:{{.Name}}.example.com,,containerName ")
+	if err != nil {
+		t.Fatalf("Unexpected error decoding hostname lookups: %s", err)
+	}
+
+	marshalled, err := json.Marshal(lookups)
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling hostname lookups: %s", err)
+	}
+
+	expected := `["env:VIRTUAL_HOST","template:{{.Name}}.example.com","containerName"]`
+	if string(marshalled) != expected {
+		t.Errorf("Expected hostname lookups to marshal as %s, got %s", expected, marshalled)
+	}
+}
+
+func TestLookupsDecodeInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []string{
+		"foo",
+		"env:VIRTUAL_HOST,env:",
+		"containerName,template:{{.Name",
+	}
+
+	for _, value := range tests {
+		var lookups hostname.Lookups
+
+		if err := lookups.Decode(value); err == nil {
+			t.Errorf("Expected an error decoding hostname lookups %q", value)
+		}
+	}
+}
+
+func TestHostnamesTemplateFailureForgotten(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	// Calling a method on a missing label fails every time the
+	// template is executed.
+	lookups := parseLookups(t, "template:{{.Config.Labels.missing.Foo}}", "containerName")
+
+	for range 2 {
+		hostnames, err := hostname.Hostnames(*data, lookups, nil, "local")
+		if err != nil {
+			t.Fatalf("Unexpected error getting hostnames: %s", err)
+		}
+
+		if len(hostnames) != 1 || hostnames[0] != "foobar-client-1.local" {
+			t.Errorf("Expected hostnames %q, got %q", []string{"foobar-client-1.local"}, hostnames)
+		}
+	}
+
+	hostname.Forget(data.ID)
+}
@@ -0,0 +1,139 @@
+package hostname
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	errRuleSyntax       = errors.New("rewrite rules must look like s/regexp/replacement/")
+	errRuleUnterminated = errors.New("unterminated rewrite rule")
+)
+
+// RewriteRule is a sed style substitution, i.e.
+// `s/^(.*)\.dev\.acme\.com$/$1/`, rewriting hostnames before they are
+// made suitable for the top-level domain. The replacement can refer to
+// submatches as `$1` or `${name}`.
+type RewriteRule struct {
+	regexp      *regexp.Regexp
+	replacement string
+	source      string
+}
+
+// Apply the rule to a hostname.
+func (r RewriteRule) Apply(hostname string) string {
+	return r.regexp.ReplaceAllString(hostname, r.replacement)
+}
+
+// String returns the rule as it was configured.
+func (r RewriteRule) String() string {
+	return r.source
+}
+
+// MarshalText returns the rule as it was configured.
+func (r RewriteRule) MarshalText() ([]byte, error) {
+	return []byte(r.source), nil
+}
+
+// RewriteRules are rewrite rules applied in order. Each rule rewrites
+// the result of the rule before it.
+type RewriteRules []RewriteRule
+
+// Decode parses rewrite rules separated by semicolons, commas or
+// whitespace, i.e. `s/\.dev\.acme\.com$//; s/^www\.//`. Any character
+// can be used as delimiter instead of `/` and a delimiter inside the
+// regular expression or the replacement is escaped with a backslash.
+func (r *RewriteRules) Decode(value string) error {
+	rules := RewriteRules{}
+	rest := value
+
+	for {
+		rest = strings.TrimLeft(rest, " \t\n;,")
+		if rest == "" {
+			break
+		}
+
+		rule, remaining, err := parseRewriteRule(rest)
+		if err != nil {
+			return err
+		}
+
+		rules = append(rules, rule)
+		rest = remaining
+	}
+
+	*r = rules
+
+	return nil
+}
+
+// Apply the rules in order to a hostname.
+func (r RewriteRules) Apply(hostname string) string {
+	for _, rule := range r {
+		hostname = rule.Apply(hostname)
+	}
+
+	return hostname
+}
+
+// parseRewriteRule parses the rule at the start of `value` and returns
+// what follows it.
+func parseRewriteRule(value string) (RewriteRule, string, error) {
+	if len(value) < 2 || value[0] != 's' {
+		return RewriteRule{}, "", fmt.Errorf("%w: %q", errRuleSyntax, value)
+	}
+
+	delimiter := value[1]
+	if delimiter == '\\' || delimiter == ' ' {
+		return RewriteRule{}, "", fmt.Errorf("%w: %q", errRuleSyntax, value)
+	}
+
+	pattern, rest, ok := cutDelimited(value[2:], delimiter)
+	if !ok {
+		return RewriteRule{}, "", fmt.Errorf("%w: %q", errRuleUnterminated, value)
+	}
+
+	replacement, rest, ok := cutDelimited(rest, delimiter)
+	if !ok {
+		return RewriteRule{}, "", fmt.Errorf("%w: %q", errRuleUnterminated, value)
+	}
+
+	if rest != "" && !strings.ContainsAny(rest[:1], " \t\n;,") {
+		return RewriteRule{}, "", fmt.Errorf("%w: unexpected %q after rule", errRuleSyntax, rest)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return RewriteRule{}, "", fmt.Errorf("compiling rewrite rule %q: %w", value[:len(value)-len(rest)], err)
+	}
+
+	rule := RewriteRule{
+		regexp:      re,
+		replacement: replacement,
+		source:      value[:len(value)-len(rest)],
+	}
+
+	return rule, rest, nil
+}
+
+// cutDelimited returns the text before the first unescaped delimiter
+// with the escaped delimiters unescaped, and the text after it.
+func cutDelimited(value string, delimiter byte) (string, string, bool) {
+	var text strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == delimiter:
+			return text.String(), value[i+1:], true
+		case value[i] == '\\' && i+1 < len(value) && value[i+1] == delimiter:
+			text.WriteByte(delimiter)
+			i++
+		default:
+			text.WriteByte(value[i])
+		}
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,134 @@
+package hostname_test
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"ldddns.arnested.dk/internal/hostname"
+)
+
+func TestRewriteRules(t *testing.T) {
+	t.Parallel()
+
+	var rules hostname.RewriteRules
+
+	err := rules.Decode(`s/^(.*)\.dev\.acme\.com$/$1/; s|^www\.||, s/a{1,2}pi/api\/v1/`)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding rewrite rules: %s", err)
+	}
+
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 rewrite rules, got %d", len(rules))
+	}
+
+	tests := map[string]string{
+		"api.dev.acme.com":     "api/v1",
+		"www.web.dev.acme.com": "web",
+		"api.acme.com":         "api/v1.acme.com",
+	}
+
+	for hostname, expected := range tests {
+		if rewritten := rules.Apply(hostname); rewritten != expected {
+			t.Errorf("Expected %q to be rewritten to %q, got %q", hostname, expected, rewritten)
+		}
+	}
+
+	marshalled, err := json.Marshal(rules)
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling rewrite rules: %s", err)
+	}
+
+	expected := `["s/^(.*)\\.dev\\.acme\\.com$/$1/","s|^www\\.||","s/a{1,2}pi/api\\/v1/"]`
+	if string(marshalled) != expected {
+		t.Errorf("Expected rewrite rules to marshal as %s, got %s", expected, marshalled)
+	}
+}
+
+func TestRewriteRulesInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []string{
+		"x/a/b/",
+		"s/a/b",
+		"s/a",
+		"s/a/b/g",
+		"s/(/b/",
+		`s\a\b\`,
+	}
+
+	for _, value := range tests {
+		var rules hostname.RewriteRules
+
+		if err := rules.Decode(value); err == nil {
+			t.Errorf("Expected an error decoding rewrite rule %q", value)
+		}
+	}
+}
+
+func TestHostnamesRewriteRules(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	data.Config.Env = []string{"VIRTUAL_HOST=api.dev.acme.com"}
+
+	var rules hostname.RewriteRules
+
+	err = rules.Decode(`s/^(.*)\.dev\.acme\.com$/$1/`)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding rewrite rules: %s", err)
+	}
+
+	hostnames, err := hostname.Hostnames(*data, parseLookups(t, "env:VIRTUAL_HOST"), rules, "local")
+	if err != nil {
+		t.Fatalf("Unexpected error getting hostnames: %s", err)
+	}
+
+	if len(hostnames) != 1 || hostnames[0] != "api.local" {
+		t.Errorf("Expected hostnames %q, got %q", []string{"api.local"}, hostnames)
+	}
+}
+
+func TestHostnamesRewriteRulesRawNames(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	data.Config.Env = []string{"VIRTUAL_HOST=www.example.com,shop.example.com"}
+
+	var rules hostname.RewriteRules
+
+	// The rules see the container name without the top-level domain
+	// and can rewrite names to nothing.
+	err = rules.Decode(`s/^foobar_client_1$/client/; s/^www\..*//`)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding rewrite rules: %s", err)
+	}
+
+	lookups := parseLookups(t, "containerName", "env:VIRTUAL_HOST")
+
+	hostnames, err := hostname.Hostnames(*data, lookups, rules, "local")
+	if err != nil {
+		t.Fatalf("Unexpected error getting hostnames: %s", err)
+	}
+
+	if expected := []string{"client.local", "shop-example.local"}; !slices.Equal(hostnames, expected) {
+		t.Errorf("Expected hostnames %q, got %q", expected, hostnames)
+	}
+
+	fqdns, err := hostname.FQDNs(*data, lookups, rules, "test")
+	if err != nil {
+		t.Fatalf("Unexpected error getting FQDNs: %s", err)
+	}
+
+	if expected := []string{"client.test", "shop.example.test"}; !slices.Equal(fqdns, expected) {
+		t.Errorf("Expected FQDNs %q, got %q", expected, fqdns)
+	}
+}
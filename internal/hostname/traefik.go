@@ -0,0 +1,228 @@
+package hostname
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"ldddns.arnested.dk/internal/container"
+	"ldddns.arnested.dk/internal/log"
+)
+
+// traefikRuleLabelRegExp matches the labels of Traefik HTTP router
+// rules, i.e. `traefik.http.routers.web.rule`.
+var traefikRuleLabelRegExp = regexp.MustCompile(`^traefik\.http\.routers\.[^.]+\.rule$`)
+
+// traefikMatcherRegExp matches the start of the `Host` and `HostRegexp`
+// matchers of a rule. Negated matchers are matched so they can be left
+// out.
+var traefikMatcherRegExp = regexp.MustCompile(`(!?\s*)\b(Host|HostRegexp)\(`)
+
+// traefikV2LabelRegExp matches a label of a Traefik v2 `HostRegexp`:
+// either a literal label or a `{name}` or `{name:regexp}` variable.
+var traefikV2LabelRegExp = regexp.MustCompile(`^(\{.*\}|[\pL\d-]+)$`)
+
+// hostnamesFromTraefik returns the hosts of the `Host` and `HostRegexp`
+// matchers in the Traefik router rules of the container sorted by
+// router.
+func hostnamesFromTraefik(containerInfo container.Container) []string {
+	hostnames := []string{}
+
+	for _, label := range slices.Sorted(maps.Keys(containerInfo.Config.Labels)) {
+		if traefikRuleLabelRegExp.MatchString(label) {
+			hostnames = append(hostnames, traefikRuleHosts(containerInfo.ID, containerInfo.Config.Labels[label])...)
+		}
+	}
+
+	return hostnames
+}
+
+// traefikRuleHosts returns the hosts of the `Host` and `HostRegexp`
+// matchers of a Traefik rule, i.e. `Host(`a.example.com`) ||
+// HostRegexp(`^.+\.example\.com$`)`. A `HostRegexp` gives the literal
+// domain the regular expression ends with. A rule we cannot parse is
+// only logged the first time for each container.
+func traefikRuleHosts(containerID string, rule string) []string {
+	hosts := []string{}
+
+	for _, match := range traefikMatcherRegExp.FindAllStringSubmatchIndex(rule, -1) {
+		negated := strings.HasPrefix(rule[match[2]:match[3]], "!")
+		matcher := rule[match[4]:match[5]]
+
+		args, ok := traefikArguments(rule[match[1]:])
+		if !ok {
+			logOnce(
+				log.PriWarning,
+				containerID,
+				"traefik:"+rule,
+				"Could not parse the arguments of %s in Traefik rule %q on container %s",
+				matcher,
+				rule,
+				containerID,
+			)
+
+			continue
+		}
+
+		if negated {
+			continue
+		}
+
+		for _, arg := range args {
+			host := arg
+			if matcher == "HostRegexp" {
+				host = hostRegexpDomain(arg)
+			}
+
+			if host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	return hosts
+}
+
+// traefikArguments parses the quoted arguments of a matcher up to the
+// closing parenthesis. Arguments are quoted with backticks or double
+// quotes.
+func traefikArguments(value string) ([]string, bool) {
+	args := []string{}
+
+	for {
+		value = strings.TrimLeft(value, " \t\n")
+		if value == "" {
+			return nil, false
+		}
+
+		quote := value[0]
+		if quote == ')' && len(args) == 0 {
+			return args, true
+		}
+
+		if quote != '`' && quote != '"' {
+			return nil, false
+		}
+
+		end := strings.IndexByte(value[1:], quote)
+		if end < 0 {
+			return nil, false
+		}
+
+		args = append(args, value[1:end+1])
+		value = strings.TrimLeft(value[end+2:], " \t\n")
+
+		switch {
+		case strings.HasPrefix(value, ","):
+			value = value[1:]
+		case strings.HasPrefix(value, ")"):
+			return args, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// hostRegexpDomain returns the literal domain at the end of a
+// `HostRegexp`. Traefik v2 uses variables like
+// `{subdomain:[a-z]+}.example.com`, and Traefik v3 uses regular
+// expressions like `^.+\.example\.com$`. Both give `example.com`.
+func hostRegexpDomain(expression string) string {
+	if domain, ok := hostRegexpV2Domain(expression); ok {
+		return domain
+	}
+
+	return hostRegexpV3Domain(expression)
+}
+
+// hostRegexpV2Domain returns the labels after the last variable of a
+// Traefik v2 `HostRegexp`. It returns false if the expression isn't on
+// the Traefik v2 form.
+func hostRegexpV2Domain(expression string) (string, bool) {
+	labels := splitV2Labels(expression)
+	domain := []string{}
+
+	for _, label := range labels {
+		if !traefikV2LabelRegExp.MatchString(label) {
+			return "", false
+		}
+
+		if strings.HasPrefix(label, "{") {
+			domain = []string{}
+
+			continue
+		}
+
+		domain = append(domain, label)
+	}
+
+	return strings.Join(domain, "."), true
+}
+
+// splitV2Labels splits a Traefik v2 `HostRegexp` into labels at the
+// dots outside of variables.
+func splitV2Labels(expression string) []string {
+	labels := []string{}
+	depth := 0
+	start := 0
+
+	for i, r := range expression {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '.':
+			if depth == 0 {
+				labels = append(labels, expression[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(labels, expression[start:])
+}
+
+// hostRegexpV3Domain returns the whole labels of the literal suffix of
+// a Traefik v3 `HostRegexp` regular expression.
+func hostRegexpV3Domain(expression string) string {
+	expression = strings.TrimPrefix(expression, "^")
+	expression = strings.TrimSuffix(expression, "$")
+
+	literal := []byte{}
+	end := len(expression)
+
+suffix:
+	for end > 0 {
+		char := expression[end-1]
+		escaped := end > 1 && expression[end-2] == '\\'
+
+		switch {
+		case char == '.' && escaped:
+			literal = append(literal, char)
+			end -= 2
+		case isLabelChar(char) && !escaped:
+			literal = append(literal, char)
+			end--
+		default:
+			break suffix
+		}
+	}
+
+	slices.Reverse(literal)
+	domain := string(literal)
+
+	// A partial label before the literal suffix, i.e. `web` in
+	// `[0-9]+web\.example\.com`, is part of the variable label.
+	if end > 0 {
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+
+	return strings.Trim(domain, ".")
+}
+
+// isLabelChar tells whether the character is a letter, digit or hyphen.
+func isLabelChar(char byte) bool {
+	return char == '-' || ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || ('0' <= char && char <= '9')
+}
@@ -0,0 +1,76 @@
+package hostname_test
+
+import (
+	"slices"
+	"testing"
+
+	"ldddns.arnested.dk/internal/hostname"
+)
+
+func TestHostnamesTraefik(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	data.Config.Labels = map[string]string{
+		"traefik.http.routers.web.rule":     "Host(`a.example.com`) || Host(`b.example.com`)",
+		"traefik.http.routers.api.rule":     "Host(`api.example.com`) && PathPrefix(`/v1`)",
+		"traefik.http.routers.admin.rule":   "Host(\"admin.example.com\", \"admin.example.org\") && !Host(`a.example.com`)",
+		"traefik.http.routers.v2.rule":      "HostRegexp(`{subdomain:[a-z]+}.shop.com`)",
+		"traefik.http.routers.v3.rule":      "HostRegexp(`^(www|shop)\\.store\\.com$`)",
+		"traefik.http.routers.partial.rule": "HostRegexp(`^[0-9]+web\\.partial\\.com$`)",
+		"traefik.http.routers.broken.rule":  "Host(a.example.com)",
+		"traefik.http.services.web.rule":    "Host(`service.example.com`)",
+		"traefik.tcp.routers.db.rule":       "HostSNI(`db.example.com`)",
+	}
+
+	hostnames, err := hostname.Hostnames(*data, parseLookups(t, "traefik"), nil, "local")
+	if err != nil {
+		t.Fatalf("Unexpected error getting hostnames: %s", err)
+	}
+
+	expected := []string{
+		"admin-example.local",
+		"api-example.local",
+		"partial.local",
+		"shop.local",
+		"store.local",
+		"a-example.local",
+		"b-example.local",
+	}
+
+	if !slices.Equal(hostnames, expected) {
+		t.Errorf("Expected hostnames %q, got %q", expected, hostnames)
+	}
+}
+
+func TestHostnamesTraefikBrokenRule(t *testing.T) {
+	t.Parallel()
+
+	data, err := containerData()
+	if err != nil {
+		t.Fatalf("getting test data: %s", err)
+	}
+
+	data.Config.Labels = map[string]string{
+		"traefik.http.routers.broken.rule": "Host(a.example.com) || Host(`b.example.com`)",
+	}
+
+	// The broken rule is only logged the first time, but the hosts we
+	// can parse are looked up every time.
+	for range 2 {
+		hostnames, err := hostname.Hostnames(*data, parseLookups(t, "traefik"), nil, "local")
+		if err != nil {
+			t.Fatalf("Unexpected error getting hostnames: %s", err)
+		}
+
+		if expected := []string{"b-example.local"}; !slices.Equal(hostnames, expected) {
+			t.Errorf("Expected hostnames %q, got %q", expected, hostnames)
+		}
+	}
+
+	hostname.Forget(data.ID)
+}
@@ -0,0 +1,123 @@
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Socket is the socket the system Podman service serves its Docker
+// compatible API on. The sockets of rootless Podman services belong to
+// their users and must be configured as endpoints along with a service
+// unit relaxed to reach them.
+const Socket = "/run/podman/podman.sock"
+
+// EngineName is the name Podman reports as a component in the Docker
+// version API.
+const EngineName = "Podman Engine"
+
+var (
+	errUnsupportedHost  = errors.New("unsupported host")
+	errUnexpectedStatus = errors.New("unexpected status")
+)
+
+// Client talks to the native (libpod) API of Podman for the
+// information the Docker compatible API doesn't have.
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// Pod holds information about a Podman pod.
+type Pod struct {
+	ID               string `json:"Id"`
+	Name             string `json:"Name"`
+	InfraContainerID string `json:"InfraContainerID"`
+}
+
+// New creates a client for the Podman service at `host`, i.e.
+// `unix:///run/podman/podman.sock` or `tcp://127.0.0.1:8080`.
+func New(host string) (*Client, error) {
+	proto, addr, found := strings.Cut(host, "://")
+	if !found {
+		return nil, fmt.Errorf("%w: %q", errUnsupportedHost, host)
+	}
+
+	switch proto {
+	case "unix":
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+
+				return dialer.DialContext(ctx, "unix", addr)
+			},
+		}
+
+		return &Client{
+			httpClient: &http.Client{Transport: transport},
+			baseURL:    "http://podman",
+		}, nil
+	case "tcp", "http":
+		return &Client{
+			httpClient: http.DefaultClient,
+			baseURL:    "http://" + addr,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnsupportedHost, host)
+	}
+}
+
+// ContainerPod returns the pod of a container. The pod is empty if
+// the container is not member of a pod.
+func (c *Client) ContainerPod(ctx context.Context, containerID string) (Pod, error) {
+	var container struct {
+		Pod string `json:"Pod"`
+	}
+
+	err := c.get(ctx, "/libpod/containers/"+url.PathEscape(containerID)+"/json", &container)
+	if err != nil {
+		return Pod{}, fmt.Errorf("inspecting container %s: %w", containerID, err)
+	}
+
+	if container.Pod == "" {
+		return Pod{}, nil
+	}
+
+	var pod Pod
+
+	err = c.get(ctx, "/libpod/pods/"+url.PathEscape(container.Pod)+"/json", &pod)
+	if err != nil {
+		return Pod{}, fmt.Errorf("inspecting pod %s: %w", container.Pod, err)
+	}
+
+	return pod, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("requesting %s: %w %s", path, errUnexpectedStatus, resp.Status)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	return nil
+}
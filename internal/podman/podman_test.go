@@ -0,0 +1,63 @@
+package podman_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ldddns.arnested.dk/internal/podman"
+)
+
+func TestNewUnsupportedHost(t *testing.T) {
+	t.Parallel()
+
+	for _, host := range []string{"/run/podman/podman.sock", "ssh://user@example.com"} {
+		if _, err := podman.New(host); err == nil {
+			t.Errorf("Expected error for host %q", host)
+		}
+	}
+}
+
+func TestContainerPod(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /libpod/containers/member/json", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"Id": "member", "Pod": "1234"}`)
+	})
+	mux.HandleFunc("GET /libpod/containers/loner/json", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"Id": "loner", "Pod": ""}`)
+	})
+	mux.HandleFunc("GET /libpod/pods/1234/json", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"Id": "1234", "Name": "shop", "InfraContainerID": "infra"}`)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := podman.New("tcp://" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	pod, err := client.ContainerPod(t.Context(), "member")
+	if err != nil {
+		t.Fatalf("Could not get pod: %v", err)
+	}
+
+	expected := podman.Pod{ID: "1234", Name: "shop", InfraContainerID: "infra"}
+	if pod != expected {
+		t.Errorf("Expected %v, got %v", expected, pod)
+	}
+
+	pod, err = client.ContainerPod(t.Context(), "loner")
+	if err != nil || pod != (podman.Pod{}) {
+		t.Errorf("Expected no pod, got %v (%v)", pod, err)
+	}
+
+	_, err = client.ContainerPod(t.Context(), "unknown")
+	if err == nil {
+		t.Error("Expected error for unknown container")
+	}
+}
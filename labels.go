@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/moby/moby/client"
+	internalContainer "ldddns.arnested.dk/internal/container"
+	"ldddns.arnested.dk/internal/hostname"
+	"ldddns.arnested.dk/internal/log"
+)
+
+// labelPrefix is the namespace of the container labels overriding the
+// global configuration.
+const labelPrefix = "ldddns."
+
+// containerConfig returns the configuration to use for a container:
+// the global configuration with the overrides from the container's
+// labels applied.
+func containerConfig(containerInfo internalContainer.Container, config Config) Config {
+	labels := containerInfo.Config.Labels
+
+	if hostnameLookup, ok := labels[labelPrefix+"hostname-lookup"]; ok {
+		if lookups, err := hostname.ParseLookups(splitList(hostnameLookup)); err == nil {
+			config.HostnameLookup = lookups
+		} else {
+			log.Logf(log.PriWarning, "Ignoring invalid hostname lookup %q on container %s", hostnameLookup, containerInfo.ID)
+		}
+	}
+
+	if oneoff, ok := labels[labelPrefix+"ignore-docker-compose-oneoff"]; ok {
+		config.IgnoreDockerComposeOneoff = boolLabel(
+			containerInfo,
+			"ignore-docker-compose-oneoff",
+			oneoff,
+			config.IgnoreDockerComposeOneoff,
+		)
+	}
+
+	if ipFamily, ok := labels[labelPrefix+"ip-family"]; ok {
+		if validIPFamily(ipFamily) {
+			config.IPFamily = ipFamily
+		} else {
+			log.Logf(log.PriWarning, "Ignoring invalid IP family %q on container %s", ipFamily, containerInfo.ID)
+		}
+	}
+
+	if networks, ok := labels[labelPrefix+"networks"]; ok {
+		config.Networks = splitList(networks)
+	}
+
+	if serviceHostnames, ok := labels[labelPrefix+"service-hostnames"]; ok {
+		if validServiceHostnames(serviceHostnames) {
+			config.ServiceHostnames = serviceHostnames
+		} else {
+			log.Logf(log.PriWarning, "Ignoring invalid service hostnames %q on container %s", serviceHostnames, containerInfo.ID)
+		}
+	}
+
+	if servicePorts, ok := labels[labelPrefix+"service-ports"]; ok {
+		if validServicePorts(servicePorts) {
+			config.ServicePorts = servicePorts
+		} else {
+			log.Logf(log.PriWarning, "Ignoring invalid service ports %q on container %s", servicePorts, containerInfo.ID)
+		}
+	}
+
+	if tld, ok := labels[labelPrefix+"tld"]; ok {
+		config.TLD = tld
+	}
+
+	if wildcard, ok := labels[labelPrefix+"wildcard"]; ok {
+		config.Wildcard = boolLabel(containerInfo, "wildcard", wildcard, config.Wildcard)
+	}
+
+	return config
+}
+
+// enabled tells whether a container should be published. The
+// `ldddns.enable` label overrides the global default and the
+// `ldddns.ignore` label overrides both.
+//
+// The `ldddns.enable` label must be exactly `true` or `false`: in
+// opt-in mode the Docker API only lists the containers labelled
+// `ldddns.enable=true` (see enabledFilter).
+func enabled(containerInfo internalContainer.Container, config Config) bool {
+	enable := config.ExposeByDefault
+
+	if value, ok := containerInfo.Config.Labels[labelPrefix+"enable"]; ok {
+		switch value {
+		case "true":
+			enable = true
+		case "false":
+			enable = false
+		default:
+			log.Logf(
+				log.PriWarning,
+				"Ignoring invalid %senable label %q on container %s, must be true or false",
+				labelPrefix,
+				value,
+				containerInfo.ID,
+			)
+		}
+	}
+
+	if value, ok := containerInfo.Config.Labels[labelPrefix+"ignore"]; ok {
+		enable = enable && !boolLabel(containerInfo, "ignore", value, false)
+	}
+
+	if !enable {
+		log.Logf(log.PriNotice, "Ignoring disabled container: %s", containerInfo.ID)
+	}
+
+	return enable
+}
+
+// enabledFilter narrows a Docker API filter to the containers enabled
+// with the `ldddns.enable` label when containers are not exposed by
+// default.
+func enabledFilter(filter client.Filters, config Config) client.Filters {
+	if !config.ExposeByDefault {
+		filter.Add("label", labelPrefix+"enable=true")
+	}
+
+	return filter
+}
+
+// splitList splits the comma separated list of a label.
+func splitList(value string) []string {
+	list := []string{}
+
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// boolLabel parses the value of a boolean label. Invalid values are
+// logged and the fallback is returned instead.
+func boolLabel(containerInfo internalContainer.Container, name string, value string, fallback bool) bool {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Logf(log.PriWarning, "Ignoring invalid %s%s label %q on container %s", labelPrefix, name, value, containerInfo.ID)
+
+		return fallback
+	}
+
+	return parsed
+}
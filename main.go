@@ -3,19 +3,24 @@ package main
 import (
 	"context"
 	_ "embed"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
 	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/carlmjohnson/versioninfo"
 	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/godbus/dbus/v5"
 	"github.com/google/gops/agent"
-	"github.com/holoplot/go-avahi"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/moby/moby/client"
+	"ldddns.arnested.dk/internal/hostname"
 	"ldddns.arnested.dk/internal/log"
 )
 
@@ -30,9 +35,142 @@ var (
 //
 //nolint:lll
 type Config struct {
-	Gops                      bool     `default:"false"                          json:"Gops"                      split_words:"true"`
-	HostnameLookup            []string `default:"env:VIRTUAL_HOST,containerName" json:"HostnameLookup"            split_words:"true"`
-	IgnoreDockerComposeOneoff bool     `default:"true"                           json:"IgnoreDockerComposeOneoff" split_words:"true"`
+	CollisionPolicy           string                `default:"rename"                                          json:"CollisionPolicy"           split_words:"true"`
+	DNSDomain                 string                `default:"test"                                            json:"DNSDomain"                 split_words:"true"`
+	DNSListen                 string                `default:""                                                json:"DNSListen"                 split_words:"true"`
+	Endpoints                 []string              `default:""                                                json:"Endpoints"`
+	ExposeByDefault           bool                  `default:"true"                                            json:"ExposeByDefault"           split_words:"true"`
+	Gops                      bool                  `default:"false"                                           json:"Gops"                      split_words:"true"`
+	HostnameLookup            hostname.Lookups      `default:"env:VIRTUAL_HOST,containerName"                  json:"HostnameLookup"            split_words:"true"`
+	HostnamePolicy            string                `default:"first-wins"                                      json:"HostnamePolicy"            split_words:"true"`
+	IgnoreDockerComposeOneoff bool                  `default:"true"                                            json:"IgnoreDockerComposeOneoff" split_words:"true"`
+	IPFamily                  string                `default:"ipv4"                                            json:"IPFamily"                  split_words:"true"`
+	Networks                  []string              `default:""                                                json:"Networks"`
+	ReconcileInterval         time.Duration         `default:"5m"                                              json:"ReconcileInterval"         split_words:"true"`
+	RewriteRules              hostname.RewriteRules `default:""                                                json:"RewriteRules"              split_words:"true"`
+	ServiceHostnames          string                `default:"all"                                             json:"ServiceHostnames"          split_words:"true"`
+	ServicePorts              string                `default:"container"                                       json:"ServicePorts"              split_words:"true"`
+	TLD                       string                `default:"local"                                           json:"TLD"`
+	TXTRecords                []string              `default:"container,image,compose_project,compose_service" json:"TXTRecords"                split_words:"true"`
+	Wildcard                  bool                  `default:"false"                                           json:"Wildcard"`
+}
+
+// IP families to publish addresses for.
+const (
+	ipFamilyIPv4 = "ipv4"
+	ipFamilyIPv6 = "ipv6"
+	ipFamilyBoth = "both"
+)
+
+// validate checks the configuration values that envconfig cannot
+// check by itself.
+func (c Config) validate() error {
+	if !validIPFamily(c.IPFamily) {
+		return fmt.Errorf(
+			"invalid IP family %q, must be one of %q, %q or %q",
+			c.IPFamily,
+			ipFamilyIPv4,
+			ipFamilyIPv6,
+			ipFamilyBoth,
+		)
+	}
+
+	if c.CollisionPolicy != collisionPolicyRename && c.CollisionPolicy != collisionPolicyIgnore {
+		return fmt.Errorf(
+			"invalid collision policy %q, must be one of %q or %q",
+			c.CollisionPolicy,
+			collisionPolicyRename,
+			collisionPolicyIgnore,
+		)
+	}
+
+	if !validHostnamePolicy(c.HostnamePolicy) {
+		return fmt.Errorf(
+			"invalid hostname policy %q, must be one of %q, %q or %q",
+			c.HostnamePolicy,
+			hostnamePolicyFirstWins,
+			hostnamePolicyNewestWins,
+			hostnamePolicyPriority,
+		)
+	}
+
+	for i, endpoint := range c.Endpoints {
+		_, err := client.ParseHostURL(endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint: %w", err)
+		}
+
+		if slices.Contains(c.Endpoints[:i], endpoint) {
+			return fmt.Errorf("duplicate endpoint %q", endpoint)
+		}
+	}
+
+	if !validServiceHostnames(c.ServiceHostnames) {
+		return fmt.Errorf(
+			"invalid service hostnames %q, must be one of %q or %q",
+			c.ServiceHostnames,
+			serviceHostnamesAll,
+			serviceHostnamesFirst,
+		)
+	}
+
+	if !validServicePorts(c.ServicePorts) {
+		return fmt.Errorf(
+			"invalid service ports %q, must be one of %q, %q or %q",
+			c.ServicePorts,
+			servicePortsContainer,
+			servicePortsHost,
+			servicePortsHostOnly,
+		)
+	}
+
+	for _, key := range c.TXTRecords {
+		if !knownTXTKey(key) {
+			return fmt.Errorf("invalid TXT record key %q", key)
+		}
+	}
+
+	for _, rule := range c.Networks {
+		if strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rule), "!")) == "" {
+			return fmt.Errorf("invalid network %q", rule)
+		}
+	}
+
+	if c.DNSListen != "" {
+		host, _, err := net.SplitHostPort(c.DNSListen)
+		if err != nil {
+			return fmt.Errorf("invalid DNS listen address %q: %w", c.DNSListen, err)
+		}
+
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !addr.IsLoopback() {
+			return fmt.Errorf("DNS listen address %q is not a loopback address", c.DNSListen)
+		}
+
+		if c.dnsDomain() == "" {
+			return errors.New("the DNS domain cannot be empty")
+		}
+	}
+
+	return nil
+}
+
+// domain returns the top-level domain to publish names under.
+func (c Config) domain() string {
+	if domain := strings.Trim(c.TLD, "."); domain != "" {
+		return domain
+	}
+
+	return tld
+}
+
+// dnsDomain returns the domain the DNS server answers for.
+func (c Config) dnsDomain() string {
+	return strings.ToLower(strings.Trim(c.DNSDomain, "."))
+}
+
+func validIPFamily(ipFamily string) bool {
+	return ipFamily == ipFamilyIPv4 || ipFamily == ipFamilyIPv6 || ipFamily == ipFamilyBoth
 }
 
 func main() {
@@ -55,65 +193,83 @@ func main() {
 		panic(fmt.Errorf("could not read environment config: %w", err))
 	}
 
-	gops(config.Gops)
-
-	docker, err := client.New(client.FromEnv)
+	err = config.validate()
 	if err != nil {
-		panic(fmt.Errorf("cannot create docker client: %w", err))
+		panic(fmt.Errorf("invalid environment config: %w", err))
 	}
-	defer docker.Close()
+
+	gops(config.Gops)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	containerEngines, err := newEngines(ctx, config.Endpoints)
+	if err != nil {
+		panic(fmt.Errorf("cannot create docker client: %w", err))
+	}
+	defer containerEngines.close()
+
 	conn, err := dbus.SystemBus()
 	if err != nil {
 		panic(fmt.Errorf("cannot get dbus system bus: %w", err))
 	}
 	defer conn.Close()
 
-	avahiServer, err := avahi.ServerNew(conn)
+	avahiServer, err := newAvahiServer()
 	if err != nil {
-		panic(fmt.Errorf("avahi new failed: %w", err))
+		panic(err)
 	}
-	defer avahiServer.Close()
 
-	egs := newEntryGroups(avahiServer)
+	status := newDaemonStatus(version, config)
+	egs := newEntryGroups(avahiServer, status, config)
+	defer egs.close()
+
+	var dns *dnsServer
+
+	if config.DNSListen != "" {
+		dns = newDNSServer(config.dnsDomain())
+
+		err = dns.listen(ctx, config.DNSListen)
+		if err != nil {
+			panic(fmt.Errorf("cannot start DNS server: %w", err))
+		}
+	}
 
 	started := time.Now()
 
-	err = sdNotify(daemon.SdNotifyReady, version, config)
+	err = status.notify(daemon.SdNotifyReady)
 	if err != nil {
 		panic(fmt.Errorf("notifying systemd we're ready: %w", err))
 	}
 
 	// Do the magic work.
-	handleExistingContainers(ctx, config, docker, egs)
-	listen(ctx, config, docker, egs, started)
+	for _, docker := range containerEngines {
+		handleExistingContainers(ctx, config, docker, egs, dns)
 
-	err = sdNotify(daemon.SdNotifyStopping, version, config)
-	if err != nil {
-		log.Logf(log.PriErr, "notifying systemd we're shutting down: %v", err)
+		go reconcileLoop(ctx, config, docker, egs, dns)
 	}
-}
 
-func sdNotify(state string, version string, config Config) error {
-	cfg, err := json.Marshal(config)
+	go requeueLoop(ctx, config, containerEngines, egs, dns)
+
+	err = watchAvahi(ctx, conn, config, containerEngines, egs, dns, status)
 	if err != nil {
-		return fmt.Errorf("could not marshal config as JSON: %w", err)
+		log.Logf(log.PriErr, "Cannot watch for avahi-daemon restarts: %v", err)
 	}
 
-	_, err = daemon.SdNotify(true, fmt.Sprintf(
-		"%s\nSTATUS=version %s; %s",
-		state,
-		version,
-		cfg,
-	))
-	if err != nil {
-		return fmt.Errorf("failed to notify systemd: %w", err)
+	var listeners sync.WaitGroup
+
+	for _, docker := range containerEngines {
+		listeners.Go(func() {
+			listen(ctx, config, docker, egs, dns, status, started)
+		})
 	}
 
-	return nil
+	listeners.Wait()
+
+	err = status.notify(daemon.SdNotifyStopping)
+	if err != nil {
+		log.Logf(log.PriErr, "notifying systemd we're shutting down: %v", err)
+	}
 }
 
 func getVersion() string {
@@ -1,10 +1,34 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/godbus/dbus/v5"
+	"github.com/holoplot/go-avahi"
+	"github.com/kelseyhightower/envconfig"
 	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/events"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/api/types/system"
+	"github.com/moby/moby/client"
+	"golang.org/x/net/dns/dnsmessage"
 	internalContainer "ldddns.arnested.dk/internal/container"
+	"ldddns.arnested.dk/internal/hostname"
 )
 
 func createTestContainer(labels map[string]string) internalContainer.Container {
@@ -132,7 +156,7 @@ func TestNewEntryGroups(t *testing.T) {
 
 	// Test that newEntryGroups creates a valid entryGroups instance
 	// We pass nil since we're just testing the constructor logic
-	egs := newEntryGroups(nil)
+	egs := newEntryGroups(nil, nil, Config{})
 
 	if egs == nil {
 		t.Fatal("Expected newEntryGroups to return non-nil")
@@ -155,3 +179,1582 @@ func TestConstants(t *testing.T) {
 		t.Errorf("Expected tld to be 'local', got %q", tld)
 	}
 }
+
+func createTestContainerWithNetworks(labels map[string]string) internalContainer.Container {
+	containerInfo := createTestContainer(labels)
+	containerInfo.NetworkSettings = &container.NetworkSettings{
+		Networks: map[string]*network.EndpointSettings{
+			"dualstack": {
+				IPAddress:         netip.MustParseAddr("172.18.0.4"),
+				GlobalIPv6Address: netip.MustParseAddr("fd00:dead:beef::4"),
+			},
+		},
+	}
+
+	return containerInfo
+}
+
+func TestIPAddressesFamily(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		ipFamily string
+		expected []string
+	}{
+		{ipFamilyIPv4, []string{"172.18.0.4"}},
+		{ipFamilyIPv6, []string{"fd00:dead:beef::4"}},
+		{ipFamilyBoth, []string{"172.18.0.4", "fd00:dead:beef::4"}},
+	}
+
+	containerInfo := createTestContainerWithNetworks(map[string]string{})
+
+	for _, testCase := range tests {
+		t.Run(testCase.ipFamily, func(t *testing.T) {
+			t.Parallel()
+
+			ips := ipAddresses(containerInfo, testCase.ipFamily, nil)
+
+			if !slices.Equal(ips, testCase.expected) {
+				t.Errorf("Expected IP addresses %q, got %q", testCase.expected, ips)
+			}
+		})
+	}
+}
+
+func TestProtocols(t *testing.T) {
+	t.Parallel()
+
+	protos := protocols([]string{"172.18.0.4", "", "172.19.0.4", "fd00:dead:beef::4", "::ffff:172.18.0.5"})
+	expected := []int32{avahi.ProtoInet, avahi.ProtoInet6}
+
+	if !slices.Equal(protos, expected) {
+		t.Errorf("Expected protocols %v, got %v", expected, protos)
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	t.Parallel()
+
+	if name := instanceName("myapp", "myapp.local", 0); name != "myapp" {
+		t.Errorf("Expected the first instance to be named after the container, got %q", name)
+	}
+
+	if name := instanceName("myapp", "api.local", 1); name != "myapp (api.local)" {
+		t.Errorf("Expected the other instances to include the hostname, got %q", name)
+	}
+
+	// Long names are cut short on a UTF-8 boundary to fit in a DNS
+	// label.
+	long := strings.Repeat("æ", 40)
+
+	for i, hostname := range []string{"myapp.local", "api.local"} {
+		name := instanceName(long, hostname, i)
+
+		if len(name) > maxLabelLength || !utf8.ValidString(name) {
+			t.Errorf("Expected a valid UTF-8 name of at most %d bytes, got %q (%d bytes)", maxLabelLength, name, len(name))
+		}
+	}
+
+	if name := instanceName(long, "api.local", 1); !strings.HasSuffix(name, " (api.local)") {
+		t.Errorf("Expected the long name to keep the hostname, got %q", name)
+	}
+
+	if name := alternativeServiceName(long, 1); len(name) > maxLabelLength || !strings.HasSuffix(name, " #2") {
+		t.Errorf("Expected the alternative name to fit in a DNS label, got %q", name)
+	}
+}
+
+func TestContainerConfigServiceHostnames(t *testing.T) {
+	t.Parallel()
+
+	config := Config{ServiceHostnames: serviceHostnamesAll}
+
+	overridden := containerConfig(createTestContainer(map[string]string{"ldddns.service-hostnames": "first"}), config)
+	if overridden.ServiceHostnames != serviceHostnamesFirst {
+		t.Errorf(
+			"Expected label to override service hostnames to %q, got %q",
+			serviceHostnamesFirst,
+			overridden.ServiceHostnames,
+		)
+	}
+
+	invalid := containerConfig(createTestContainer(map[string]string{"ldddns.service-hostnames": "some"}), config)
+	if invalid.ServiceHostnames != serviceHostnamesAll {
+		t.Errorf("Expected invalid label to keep service hostnames %q, got %q", serviceHostnamesAll, invalid.ServiceHostnames)
+	}
+}
+
+func TestContainerConfigIPFamily(t *testing.T) {
+	t.Parallel()
+
+	config := Config{IPFamily: ipFamilyIPv4}
+
+	overridden := containerConfig(createTestContainer(map[string]string{"ldddns.ip-family": "both"}), config)
+	if overridden.IPFamily != ipFamilyBoth {
+		t.Errorf("Expected label to override IP family to %q, got %q", ipFamilyBoth, overridden.IPFamily)
+	}
+
+	invalid := containerConfig(createTestContainer(map[string]string{"ldddns.ip-family": "ipx"}), config)
+	if invalid.IPFamily != ipFamilyIPv4 {
+		t.Errorf("Expected invalid label to keep IP family %q, got %q", ipFamilyIPv4, invalid.IPFamily)
+	}
+
+	if err := (Config{IPFamily: "ipx"}).validate(); err == nil {
+		t.Error("Expected invalid IP family to fail validation")
+	}
+}
+
+func TestConfigDomain(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		tld      string
+		expected string
+	}{
+		{"", "local"},
+		{"local", "local"},
+		{"test", "test"},
+		{".internal.", "internal"},
+		{"dev.lan", "dev.lan"},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.tld, func(t *testing.T) {
+			t.Parallel()
+
+			if domain := (Config{TLD: testCase.tld}).domain(); domain != testCase.expected {
+				t.Errorf("Expected domain %q, got %q", testCase.expected, domain)
+			}
+		})
+	}
+
+	config := containerConfig(createTestContainer(map[string]string{"ldddns.tld": "test"}), Config{TLD: "local"})
+	if config.domain() != "test" {
+		t.Errorf("Expected label to override domain to %q, got %q", "test", config.domain())
+	}
+}
+
+func testDNSServer() *dnsServer {
+	dns := newDNSServer("test")
+	dns.records["test-container"] = dnsRecord{
+		hostnames: []string{"api.shop.test", "shop.test"},
+		ips:       []string{"172.18.0.4", "fd00:dead:beef::4"},
+		services:  []internalContainer.Service{{Type: "_http._tcp", Port: 80}},
+	}
+
+	return dns
+}
+
+func dnsQuery(t *testing.T, dns *dnsServer, name string, questionType dnsmessage.Type) dnsmessage.Message {
+	t.Helper()
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 4711, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: dnsmessage.MustNewName(name), Type: questionType, Class: dnsmessage.ClassINET},
+		},
+	}
+
+	request, err := query.Pack()
+	if err != nil {
+		t.Fatalf("packing DNS query: %v", err)
+	}
+
+	packed, err := dns.handle(request, dnsMaxUDPSize)
+	if err != nil {
+		t.Fatalf("handling DNS query: %v", err)
+	}
+
+	var response dnsmessage.Message
+
+	err = response.Unpack(packed)
+	if err != nil {
+		t.Fatalf("unpacking DNS response: %v", err)
+	}
+
+	if response.ID != query.ID || !response.Response {
+		t.Errorf("Expected a response to query %d, got %+v", query.ID, response.Header)
+	}
+
+	return response
+}
+
+func TestDNSServer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		questionType dnsmessage.Type
+		rcode        dnsmessage.RCode
+		answer       string
+	}{
+		{"api.shop.test.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, "172.18.0.4"},
+		{"API.Shop.test.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, "172.18.0.4"},
+		{"shop.test.", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, "fd00:dead:beef::4"},
+		{"shop.test.", dnsmessage.TypeMX, dnsmessage.RCodeSuccess, ""},
+		{"missing.test.", dnsmessage.TypeA, dnsmessage.RCodeNameError, ""},
+		{"example.com.", dnsmessage.TypeA, dnsmessage.RCodeRefused, ""},
+		{"4.0.18.172.in-addr.arpa.", dnsmessage.TypePTR, dnsmessage.RCodeSuccess, "api.shop.test."},
+		{
+			"4.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.f.e.e.b.d.a.e.d.0.0.d.f.ip6.arpa.",
+			dnsmessage.TypePTR,
+			dnsmessage.RCodeSuccess,
+			"api.shop.test.",
+		},
+		{"5.0.18.172.in-addr.arpa.", dnsmessage.TypePTR, dnsmessage.RCodeNameError, ""},
+		{"_http._tcp.test.", dnsmessage.TypePTR, dnsmessage.RCodeSuccess, "api._http._tcp.test."},
+		{"api._http._tcp.test.", dnsmessage.TypeSRV, dnsmessage.RCodeSuccess, "api.shop.test.:80"},
+		{"_services._dns-sd._udp.test.", dnsmessage.TypePTR, dnsmessage.RCodeSuccess, "_http._tcp.test."},
+	}
+
+	dns := testDNSServer()
+
+	for _, testCase := range tests {
+		t.Run(testCase.name+" "+testCase.questionType.String(), func(t *testing.T) {
+			t.Parallel()
+
+			response := dnsQuery(t, dns, testCase.name, testCase.questionType)
+
+			if response.RCode != testCase.rcode {
+				t.Errorf("Expected response code %v, got %v", testCase.rcode, response.RCode)
+			}
+
+			answers := []string{}
+
+			for _, answer := range response.Answers {
+				switch body := answer.Body.(type) {
+				case *dnsmessage.AResource:
+					answers = append(answers, netip.AddrFrom4(body.A).String())
+				case *dnsmessage.AAAAResource:
+					answers = append(answers, netip.AddrFrom16(body.AAAA).String())
+				case *dnsmessage.PTRResource:
+					answers = append(answers, body.PTR.String())
+				case *dnsmessage.SRVResource:
+					answers = append(answers, fmt.Sprintf("%s:%d", body.Target, body.Port))
+				}
+			}
+
+			if testCase.answer == "" && len(answers) != 0 {
+				t.Errorf("Expected no answers, got %q", answers)
+			}
+
+			if testCase.answer != "" && !slices.Equal(answers, []string{testCase.answer}) {
+				t.Errorf("Expected answer %q, got %q", testCase.answer, answers)
+			}
+		})
+	}
+}
+
+func TestDNSServerFQDNsPod(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := createTestContainer(map[string]string{})
+	containerInfo.InspectResponse.Name = "/shop-infra"
+
+	lookups, err := hostname.ParseLookups([]string{"containerName"})
+	if err != nil {
+		t.Fatalf("Unexpected error parsing hostname lookups: %v", err)
+	}
+
+	config := validTestConfig()
+	config.HostnameLookup = lookups
+
+	dns := newDNSServer("test")
+
+	fqdns, err := dns.fqdns(containerInfo, config, "shop")
+	if err != nil {
+		t.Fatalf("Unexpected error getting FQDNs: %v", err)
+	}
+
+	if expected := []string{"shop-infra.test", "shop.test"}; !slices.Equal(fqdns, expected) {
+		t.Errorf("Expected FQDNs %q, got %q", expected, fqdns)
+	}
+
+	var disabled *dnsServer
+
+	if fqdns, err := disabled.fqdns(containerInfo, config, "shop"); err != nil || len(fqdns) != 0 {
+		t.Errorf("Expected no FQDNs on a disabled DNS server, got %q, %v", fqdns, err)
+	}
+}
+
+func TestDNSServerRemove(t *testing.T) {
+	t.Parallel()
+
+	dns := testDNSServer()
+	dns.remove("test-container")
+
+	response := dnsQuery(t, dns, "api.shop.test.", dnsmessage.TypeA)
+	if response.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("Expected removed name to not exist, got %v", response.RCode)
+	}
+
+	// A disabled DNS server is nil and must be safe to use.
+	var disabled *dnsServer
+
+	disabled.remove("test-container")
+}
+
+// validTestConfig returns a configuration passing validation.
+func validTestConfig() Config {
+	return Config{
+		CollisionPolicy:  collisionPolicyRename,
+		DNSDomain:        "test",
+		HostnamePolicy:   hostnamePolicyFirstWins,
+		IPFamily:         ipFamilyIPv4,
+		ServiceHostnames: serviceHostnamesAll,
+		ServicePorts:     servicePortsContainer,
+	}
+}
+
+func TestConfigValidateDNSListen(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		listen string
+		valid  bool
+	}{
+		{"", true},
+		{"127.0.0.153:53", true},
+		{"[::1]:5300", true},
+		{"0.0.0.0:53", false},
+		{"192.168.1.2:53", false},
+		{"localhost:53", false},
+		{"127.0.0.153", false},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.listen, func(t *testing.T) {
+			t.Parallel()
+
+			config := validTestConfig()
+			config.DNSListen = testCase.listen
+
+			err := config.validate()
+
+			if (err == nil) != testCase.valid {
+				t.Errorf("Expected validity %v of %q, got error %v", testCase.valid, testCase.listen, err)
+			}
+		})
+	}
+}
+
+func TestConfigValidateNetworks(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]bool{
+		"backend":  true,
+		" backend": true,
+		"!backend": true,
+		"":         false,
+		" ":        false,
+		"!":        false,
+		" ! ":      false,
+	}
+
+	for network, valid := range tests {
+		config := validTestConfig()
+		config.Networks = []string{"frontend", network}
+
+		err := config.validate()
+
+		if (err == nil) != valid {
+			t.Errorf("Expected validity %v of %q, got error %v", valid, network, err)
+		}
+	}
+}
+
+func TestConfigValidateCollisionPolicy(t *testing.T) {
+	t.Parallel()
+
+	for policy, valid := range map[string]bool{"rename": true, "ignore": true, "": false, "panic": false} {
+		config := validTestConfig()
+		config.CollisionPolicy = policy
+
+		err := config.validate()
+
+		if (err == nil) != valid {
+			t.Errorf("Expected validity %v of %q, got error %v", valid, policy, err)
+		}
+	}
+}
+
+func TestDNSServerWildcard(t *testing.T) {
+	t.Parallel()
+
+	dns := testDNSServer()
+	dns.records["wildcard-container"] = dnsRecord{
+		hostnames: []string{"myapp.test"},
+		ips:       []string{"172.18.0.5"},
+		services:  []internalContainer.Service{},
+		wildcard:  true,
+	}
+	dns.records["tenant-container"] = dnsRecord{
+		hostnames: []string{"special.myapp.test"},
+		ips:       []string{"172.18.0.6"},
+		services:  []internalContainer.Service{},
+	}
+
+	tests := []struct {
+		name   string
+		answer string
+	}{
+		{"myapp.test.", "172.18.0.5"},
+		{"tenant.myapp.test.", "172.18.0.5"},
+		{"deep.tenant.myapp.test.", "172.18.0.5"},
+		{"special.myapp.test.", "172.18.0.6"},
+		{"tenant.api.shop.test.", ""},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			response := dnsQuery(t, dns, testCase.name, dnsmessage.TypeA)
+
+			if testCase.answer == "" {
+				if response.RCode != dnsmessage.RCodeNameError {
+					t.Errorf("Expected %q to not exist, got %v", testCase.name, response.RCode)
+				}
+
+				return
+			}
+
+			if len(response.Answers) != 1 {
+				t.Fatalf("Expected one answer, got %d", len(response.Answers))
+			}
+
+			body, ok := response.Answers[0].Body.(*dnsmessage.AResource)
+			if !ok || netip.AddrFrom4(body.A).String() != testCase.answer {
+				t.Errorf("Expected answer %q, got %v", testCase.answer, response.Answers[0].Body)
+			}
+		})
+	}
+}
+
+func TestDNSServerOverlappingWildcards(t *testing.T) {
+	t.Parallel()
+
+	dns := newDNSServer("test")
+	dns.records["shop-container"] = dnsRecord{
+		hostnames: []string{"shop.test"},
+		ips:       []string{"172.18.0.5"},
+		services:  []internalContainer.Service{},
+		wildcard:  true,
+	}
+	dns.records["api-container"] = dnsRecord{
+		hostnames: []string{"api.shop.test"},
+		ips:       []string{"172.18.0.6"},
+		services:  []internalContainer.Service{},
+		wildcard:  true,
+	}
+
+	tests := map[string]string{
+		"tenant.api.shop.test.": "172.18.0.6",
+		"tenant.web.shop.test.": "172.18.0.5",
+	}
+
+	for name, answer := range tests {
+		// Ask repeatedly as map order must not matter.
+		for range 10 {
+			response := dnsQuery(t, dns, name, dnsmessage.TypeA)
+
+			if len(response.Answers) != 1 {
+				t.Fatalf("Expected one answer for %q, got %d", name, len(response.Answers))
+			}
+
+			body, ok := response.Answers[0].Body.(*dnsmessage.AResource)
+			if !ok || netip.AddrFrom4(body.A).String() != answer {
+				t.Errorf("Expected answer %q for %q, got %v", answer, name, response.Answers[0].Body)
+			}
+		}
+	}
+}
+
+// fakeDockerEngine returns an engine talking to a fake Docker API
+// with the running containers and the inspect responses by container
+// ID. Containers without an inspect response are not found, and an
+// empty inspect response fails with an internal server error.
+func fakeDockerEngine(t *testing.T, running []string, inspect map[string]string) *engine {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1.47/containers/json", func(w http.ResponseWriter, _ *http.Request) {
+		containers := []string{}
+		for _, containerID := range running {
+			containers = append(containers, fmt.Sprintf(`{"Id": %q}`, containerID))
+		}
+
+		fmt.Fprint(w, "["+strings.Join(containers, ",")+"]")
+	})
+	mux.HandleFunc("GET /v1.47/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		response, ok := inspect[r.PathValue("id")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "No such container"}`)
+
+			return
+		}
+
+		if response == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"message": "Internal server error"}`)
+
+			return
+		}
+
+		fmt.Fprint(w, response)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	docker, err := client.New(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithAPIVersion("1.47"))
+	if err != nil {
+		t.Fatalf("Could not create client: %v", err)
+	}
+
+	t.Cleanup(func() { docker.Close() })
+
+	return &engine{Client: docker, endpoint: docker.DaemonHost(), podman: nil}
+}
+
+func TestReconcileActions(t *testing.T) {
+	t.Parallel()
+
+	docker := fakeDockerEngine(t, []string{"running", "missed"}, map[string]string{
+		"restarted": `{"Id": "restarted", "State": {"Running": true}}`,
+		"stopped":   `{"Id": "stopped", "State": {"Running": false}}`,
+		"withdrawn": `{"Id": "withdrawn", "State": {"Running": false}}`,
+		"failing":   "",
+	})
+
+	// Published containers and whether their entry groups are empty.
+	published := map[string]bool{
+		"running":   false,
+		"restarted": false,
+		"stopped":   false,
+		"withdrawn": true,
+		"removed":   false,
+		// A container we fail to inspect is left alone.
+		"failing": false,
+	}
+
+	actions, err := reconcileActions(t.Context(), Config{ExposeByDefault: true}, docker, published)
+	if err != nil {
+		t.Fatalf("Unexpected error reconciling: %v", err)
+	}
+
+	expected := map[string]events.Action{
+		"missed":    "start",
+		"restarted": "start",
+		"stopped":   "die",
+		"removed":   "destroy",
+	}
+
+	if !maps.Equal(actions, expected) {
+		t.Errorf("Expected reconcile actions %v, got %v", expected, actions)
+	}
+}
+
+func TestReconcileStatus(t *testing.T) {
+	t.Parallel()
+
+	docker := fakeDockerEngine(t, nil, map[string]string{
+		"running": `{"Id": "running", "State": {"Running": true}}`,
+		"stopped": `{"Id": "stopped", "State": {"Running": false}}`,
+		"failing": "",
+	})
+
+	tests := map[string]events.Action{
+		"running": "start",
+		"stopped": "die",
+		"removed": "destroy",
+	}
+
+	for containerID, expected := range tests {
+		status, err := reconcileStatus(t.Context(), docker, containerID)
+		if err != nil {
+			t.Errorf("Unexpected error getting status of %s: %v", containerID, err)
+		}
+
+		if status != expected {
+			t.Errorf("Expected status %q of %s, got %q", expected, containerID, status)
+		}
+	}
+
+	if status, err := reconcileStatus(t.Context(), docker, "failing"); err == nil {
+		t.Errorf("Expected an error inspecting a failing container, got status %q", status)
+	}
+}
+
+func TestEntryGroupsReplaceServer(t *testing.T) {
+	t.Parallel()
+
+	egs := newEntryGroups(nil, nil, Config{})
+	stale := newEntryGroup(nil)
+	egs.groups["stale-container"] = stale
+
+	egs.replaceServer(nil)
+
+	select {
+	case <-stale.stop:
+	default:
+		t.Error("Expected the watcher of the stale entry group to be stopped")
+	}
+
+	if len(egs.groups) != 0 {
+		t.Errorf("Expected no entry groups, got %d", len(egs.groups))
+	}
+}
+
+func TestEntryGroupsRemoveUnknown(t *testing.T) {
+	t.Parallel()
+
+	egs := newEntryGroups(nil, newDaemonStatus("test", Config{}), Config{})
+
+	// Removing a container we never saw must not touch Avahi.
+	egs.remove("unknown-container")
+
+	if len(egs.groups) != 0 {
+		t.Errorf("Expected no entry groups, got %d", len(egs.groups))
+	}
+}
+
+func TestDaemonStatusGroups(t *testing.T) {
+	t.Parallel()
+
+	status := newDaemonStatus("test", Config{})
+
+	// Without NOTIFY_SOCKET notifying systemd is a no-op.
+	err := status.setGroups(3)
+	if err != nil {
+		t.Errorf("Expected no error updating groups, got %v", err)
+	}
+
+	if status.groups != 3 {
+		t.Errorf("Expected 3 entry groups, got %d", status.groups)
+	}
+
+	var disabled *daemonStatus
+
+	err = disabled.setGroups(1)
+	if err != nil {
+		t.Errorf("Expected nil status to ignore group updates, got %v", err)
+	}
+}
+
+//nolint:paralleltest
+func TestDaemonStatusNotifyKeepsSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listening on fake notify socket: %v", err)
+	}
+
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	status := newDaemonStatus("test", Config{})
+
+	err = status.notify(daemon.SdNotifyReady)
+	if err != nil {
+		t.Fatalf("Expected no error notifying ready, got %v", err)
+	}
+
+	err = status.setGroups(2)
+	if err != nil {
+		t.Fatalf("Expected no error updating groups, got %v", err)
+	}
+
+	buf := make([]byte, 4096)
+
+	for _, expected := range []string{daemon.SdNotifyReady, "2 entry groups"} {
+		err = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatalf("setting read deadline: %v", err)
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Expected a notification containing %q, got %v", expected, err)
+		}
+
+		if !strings.Contains(string(buf[:n]), expected) {
+			t.Errorf("Expected notification to contain %q, got %q", expected, buf[:n])
+		}
+	}
+}
+
+func TestEventsSince(t *testing.T) {
+	t.Parallel()
+
+	since := time.Unix(1609459200, 42)
+
+	if s := eventsSince(since); s != "1609459200.000000042" {
+		t.Errorf("Expected %q, got %q", "1609459200.000000042", s)
+	}
+}
+
+func TestWaitForDockerStops(t *testing.T) {
+	t.Parallel()
+
+	sig := make(chan os.Signal, 1)
+	sig <- syscall.SIGTERM
+
+	// We are told to stop before the first attempt so Docker is
+	// never pinged.
+	if waitForDocker(t.Context(), nil, sig) {
+		t.Error("Expected waitForDocker to give up when told to stop")
+	}
+}
+
+func TestDaemonStatusDegraded(t *testing.T) {
+	t.Parallel()
+
+	status := newDaemonStatus("test", Config{})
+
+	status.degraded("Docker", errors.New("connection refused"))
+
+	if _, ok := status.problems["Docker"]; !ok {
+		t.Error("Expected Docker to be reported as unavailable")
+	}
+
+	status.recovered("Docker")
+
+	if len(status.problems) != 0 {
+		t.Errorf("Expected no problems after recovering, got %v", status.problems)
+	}
+
+	var disabled *daemonStatus
+
+	disabled.degraded("Docker", errors.New("connection refused"))
+	disabled.recovered("Docker")
+}
+
+func TestAvahiOwnerChange(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		signal   *dbus.Signal
+		owner    string
+		expected bool
+	}{
+		{
+			name: "avahi came back",
+			signal: &dbus.Signal{
+				Name: "org.freedesktop.DBus.NameOwnerChanged",
+				Body: []any{"org.freedesktop.Avahi", "", ":1.42"},
+			},
+			owner:    ":1.42",
+			expected: true,
+		},
+		{
+			name: "avahi left",
+			signal: &dbus.Signal{
+				Name: "org.freedesktop.DBus.NameOwnerChanged",
+				Body: []any{"org.freedesktop.Avahi", ":1.42", ""},
+			},
+			owner:    "",
+			expected: true,
+		},
+		{
+			name: "other name",
+			signal: &dbus.Signal{
+				Name: "org.freedesktop.DBus.NameOwnerChanged",
+				Body: []any{"org.example.Other", "", ":1.43"},
+			},
+			owner:    "",
+			expected: false,
+		},
+		{
+			name: "other signal",
+			signal: &dbus.Signal{
+				Name: "org.freedesktop.Avahi.EntryGroup.StateChanged",
+				Body: []any{int32(2), ""},
+			},
+			owner:    "",
+			expected: false,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			owner, changed := avahiOwnerChange(testCase.signal)
+
+			if changed != testCase.expected || owner != testCase.owner {
+				t.Errorf("Expected (%q, %v), got (%q, %v)", testCase.owner, testCase.expected, owner, changed)
+			}
+		})
+	}
+}
+
+func TestAlternativeNames(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		hostname    string
+		service     string
+		collisions  int
+		hostnameOut string
+		serviceOut  string
+	}{
+		{"myapp.local", "myapp", 0, "myapp.local", "myapp"},
+		{"myapp.local", "myapp", 1, "myapp-2.local", "myapp #2"},
+		{"api.shop.test", "shop", 2, "api-3.shop.test", "shop #3"},
+		{"myapp", "myapp", 1, "myapp-2", "myapp #2"},
+	}
+
+	for _, testCase := range tests {
+		if got := alternativeHostname(testCase.hostname, testCase.collisions); got != testCase.hostnameOut {
+			t.Errorf("Expected hostname %q, got %q", testCase.hostnameOut, got)
+		}
+
+		if got := alternativeServiceName(testCase.service, testCase.collisions); got != testCase.serviceOut {
+			t.Errorf("Expected service name %q, got %q", testCase.serviceOut, got)
+		}
+	}
+}
+
+func TestCollided(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		policy     string
+		collisions int
+		expected   []string
+	}{
+		{collisionPolicyRename, 0, []string{"abc"}},
+		{collisionPolicyRename, maxCollisionRenames, []string{}},
+		{collisionPolicyIgnore, 0, []string{}},
+	}
+
+	for _, testCase := range tests {
+		egs := newEntryGroups(nil, nil, Config{CollisionPolicy: testCase.policy})
+		group := newEntryGroup(nil)
+		group.collisions = testCase.collisions
+
+		egs.collided("abc", group)
+
+		if got := egs.requeue.drain(); !slices.Equal(got, testCase.expected) {
+			t.Errorf("Expected requeued %v with policy %q, got %v", testCase.expected, testCase.policy, got)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	t.Parallel()
+
+	q := newQueue()
+	q.push("b", "a")
+	q.push("b")
+
+	select {
+	case <-q.ready:
+	default:
+		t.Fatal("Expected queue to be ready")
+	}
+
+	if got := q.drain(); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("Expected [a b], got %v", got)
+	}
+
+	if got := q.drain(); len(got) != 0 {
+		t.Errorf("Expected empty queue, got %v", got)
+	}
+}
+
+func TestHostnameOwners(t *testing.T) {
+	t.Parallel()
+
+	older := hostnameClaim{containerID: "old", containerName: "old", started: time.Unix(100, 0), priority: 0}
+	newer := hostnameClaim{containerID: "new", containerName: "new", started: time.Unix(200, 0), priority: 0}
+	preferred := hostnameClaim{containerID: "pri", containerName: "pri", started: time.Unix(300, 0), priority: 10}
+
+	tests := []struct {
+		policy string
+		owner  string
+	}{
+		{hostnamePolicyFirstWins, "old"},
+		{hostnamePolicyNewestWins, "pri"},
+		{hostnamePolicyPriority, "pri"},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.policy, func(t *testing.T) {
+			t.Parallel()
+
+			owners := newHostnameOwners(testCase.policy)
+
+			for _, claim := range []hostnameClaim{older, newer, preferred} {
+				owners.claim(claim, []string{"web.local", claim.containerID + ".local"})
+			}
+
+			for _, claim := range []hostnameClaim{older, newer, preferred} {
+				owned, _, _ := owners.claim(claim, []string{"web.local", claim.containerID + ".local"})
+				expected := []string{claim.containerID + ".local"}
+
+				if claim.containerID == testCase.owner {
+					expected = []string{"web.local", claim.containerID + ".local"}
+				}
+
+				if !slices.Equal(owned, expected) {
+					t.Errorf("Expected %s to own %v, got %v", claim.containerID, expected, owned)
+				}
+			}
+		})
+	}
+}
+
+func TestHostnameOwnersFailover(t *testing.T) {
+	t.Parallel()
+
+	owners := newHostnameOwners(hostnamePolicyFirstWins)
+	older := hostnameClaim{containerID: "old", containerName: "old", started: time.Unix(100, 0), priority: 0}
+	newer := hostnameClaim{containerID: "new", containerName: "new", started: time.Unix(200, 0), priority: 0}
+
+	owned, changed, _ := owners.claim(newer, []string{"web.local"})
+	if !slices.Equal(owned, []string{"web.local"}) || len(changed) != 0 {
+		t.Errorf("Expected new to own web.local without changes, got %v and %v", owned, changed)
+	}
+
+	owned, changed, taken := owners.claim(older, []string{"web.local"})
+	if !slices.Equal(owned, []string{"web.local"}) || !slices.Equal(changed, []string{"new"}) {
+		t.Errorf("Expected old to take over web.local from new, got %v and %v", owned, changed)
+	}
+
+	if !slices.Equal(taken, []string{"new"}) {
+		t.Errorf("Expected web.local to be taken from new, got %v", taken)
+	}
+
+	if changed := owners.release("old"); !slices.Equal(changed, []string{"new"}) {
+		t.Errorf("Expected web.local to fail over to new, got %v", changed)
+	}
+
+	if changed := owners.release("new"); len(changed) != 0 {
+		t.Errorf("Expected no fail over, got %v", changed)
+	}
+}
+
+func TestHostnameOwnersSecondWins(t *testing.T) {
+	t.Parallel()
+
+	first := hostnameClaim{containerID: "first", containerName: "first", started: time.Unix(100, 0), priority: 0}
+	second := hostnameClaim{containerID: "second", containerName: "second", started: time.Unix(200, 0), priority: 0}
+
+	for _, policy := range []string{hostnamePolicyNewestWins, hostnamePolicyPriority} {
+		owners := newHostnameOwners(policy)
+		claim := second
+
+		if policy == hostnamePolicyPriority {
+			claim.priority = 10
+		}
+
+		owners.claim(first, []string{"web.local"})
+
+		owned, changed, taken := owners.claim(claim, []string{"web.local"})
+		if !slices.Equal(owned, []string{"web.local"}) {
+			t.Errorf("Expected second to own web.local with %s, got %v", policy, owned)
+		}
+
+		// The first container still publishes web.local and must be
+		// withdrawn before the second publishes it, and republished
+		// without it.
+		if !slices.Equal(taken, []string{"first"}) || !slices.Equal(changed, []string{"first"}) {
+			t.Errorf("Expected web.local to be taken from first with %s, got %v and %v", policy, taken, changed)
+		}
+
+		owned, _, _ = owners.claim(first, []string{"web.local"})
+		if len(owned) != 0 {
+			t.Errorf("Expected first to own nothing with %s, got %v", policy, owned)
+		}
+	}
+
+	// Withdrawing containers without entry groups doesn't touch
+	// Avahi.
+	newEntryGroups(nil, nil, Config{}).withdraw([]string{"first"})
+}
+
+func TestClaimNamesDNSServer(t *testing.T) {
+	t.Parallel()
+
+	egs := newEntryGroups(nil, nil, Config{HostnamePolicy: hostnamePolicyNewestWins})
+	dns := newDNSServer("test")
+
+	first := hostnameClaim{containerID: "first", containerName: "first", started: time.Unix(100, 0), priority: 0}
+	second := hostnameClaim{containerID: "second", containerName: "second", started: time.Unix(200, 0), priority: 0}
+
+	hostnames, fqdns := claimNames(egs, dns, first, []string{"web.local", "first.local"}, []string{"web.test"})
+	if !slices.Equal(hostnames, []string{"web.local", "first.local"}) || !slices.Equal(fqdns, []string{"web.test"}) {
+		t.Errorf("Expected first to own all its names, got %q and %q", hostnames, fqdns)
+	}
+
+	dns.publish("first", fqdns, createTestContainerWithNetworks(nil), Config{}, []string{"172.18.0.4"})
+
+	// The second container takes web.local and web.test. The first
+	// container no longer answers for web.test on the DNS server
+	// until it is republished from the requeue without it.
+	hostnames, fqdns = claimNames(egs, dns, second, []string{"web.local"}, []string{"web.test"})
+	if !slices.Equal(hostnames, []string{"web.local"}) || !slices.Equal(fqdns, []string{"web.test"}) {
+		t.Errorf("Expected second to own web.local and web.test, got %q and %q", hostnames, fqdns)
+	}
+
+	if _, ok := dns.records["first"]; ok {
+		t.Error("Expected the DNS records of first to be withdrawn")
+	}
+
+	if requeued := egs.requeue.drain(); !slices.Equal(requeued, []string{"first"}) {
+		t.Errorf("Expected first to be requeued, got %q", requeued)
+	}
+
+	hostnames, fqdns = claimNames(egs, dns, first, []string{"web.local", "first.local"}, []string{"web.test"})
+	if !slices.Equal(hostnames, []string{"first.local"}) || len(fqdns) != 0 {
+		t.Errorf("Expected first to only own first.local, got %q and %q", hostnames, fqdns)
+	}
+}
+
+func TestEventAction(t *testing.T) {
+	t.Parallel()
+
+	tests := map[events.Action]events.Action{
+		"start":   "start",
+		"die":     "die",
+		"died":    "die",
+		"destroy": "destroy",
+		"remove":  "destroy",
+	}
+
+	for action, expected := range tests {
+		if got := eventAction(action); got != expected {
+			t.Errorf("Expected %q to become %q, got %q", action, expected, got)
+		}
+	}
+}
+
+func TestIsPodman(t *testing.T) {
+	t.Parallel()
+
+	docker := client.ServerVersionResult{
+		Components: []system.ComponentVersion{{Name: "Engine", Version: "28.0.0", Details: nil}},
+	}
+	podman := client.ServerVersionResult{
+		Components: []system.ComponentVersion{{Name: "Podman Engine", Version: "5.4.0", Details: nil}},
+	}
+
+	if isPodman(docker) {
+		t.Error("Expected Docker not to be Podman")
+	}
+
+	if !isPodman(podman) {
+		t.Error("Expected Podman to be Podman")
+	}
+}
+
+func TestNewEnginesRootlessPodman(t *testing.T) {
+	t.Parallel()
+
+	// A rootless Podman socket, i.e. /run/user/1000/podman/podman.sock,
+	// configured as an endpoint.
+	socket := filepath.Join(t.TempDir(), "podman.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Could not listen on %s: %v", socket, err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/version") {
+			fmt.Fprint(w, `{"Components": [{"Name": "Podman Engine", "Version": "5.4.0"}], "ApiVersion": "1.41"}`)
+
+			return
+		}
+
+		w.Header().Set("Api-Version", "1.41")
+		fmt.Fprint(w, "OK")
+	}))
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	containerEngines, err := newEngines(t.Context(), []string{"unix://" + socket})
+	if err != nil {
+		t.Fatalf("Unexpected error connecting to the engines: %v", err)
+	}
+
+	t.Cleanup(containerEngines.close)
+
+	if len(containerEngines) != 1 || containerEngines[0].endpoint != "unix://"+socket {
+		t.Fatalf("Expected the engine at %s, got %v", socket, containerEngines)
+	}
+
+	if containerEngines[0].podman == nil {
+		t.Error("Expected the endpoint to be detected as Podman")
+	}
+}
+
+//nolint:paralleltest
+func TestNewEnginesEndpointIgnoresEnvironment(t *testing.T) {
+	// TLS settings for the engine in DOCKER_HOST with certificates
+	// that don't exist.
+	t.Setenv("DOCKER_HOST", "tcp://docker.example.com:2376")
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	t.Setenv("DOCKER_CERT_PATH", filepath.Join(t.TempDir(), "missing"))
+
+	if _, err := newEngine(t.Context(), ""); err == nil {
+		t.Error("Expected an error using the TLS settings from the environment")
+	}
+
+	docker := fakeDockerEngine(t, nil, nil)
+
+	containerEngines, err := newEngines(t.Context(), []string{docker.endpoint})
+	if err != nil {
+		t.Fatalf("Expected the endpoint not to use the TLS settings from the environment, got: %v", err)
+	}
+
+	t.Cleanup(containerEngines.close)
+
+	if containerEngines[0].endpoint != docker.endpoint {
+		t.Errorf("Expected the engine at %s, got %s", docker.endpoint, containerEngines[0].endpoint)
+	}
+}
+
+func TestPodmanHostDockerHost(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "unix:///run/user/1000/docker.sock")
+
+	if host := podmanHost(); host != "" {
+		t.Errorf("Expected DOCKER_HOST to take precedence, got %q", host)
+	}
+}
+
+func TestContainerIPAddressesOwnNetwork(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := createTestContainerWithNetworks(nil)
+
+	ips, err := containerIPAddresses(t.Context(), nil, containerInfo, validTestConfig())
+	if err != nil || !slices.Equal(ips, ipAddresses(containerInfo, ipFamilyIPv4, nil)) {
+		t.Errorf("Expected the container's own addresses, got %v (%v)", ips, err)
+	}
+}
+
+func TestEngineKeys(t *testing.T) {
+	t.Parallel()
+
+	system := &engine{Client: nil, endpoint: "unix:///var/run/docker.sock", podman: nil}
+	rootless := &engine{Client: nil, endpoint: "unix:///run/user/1000/docker.sock", podman: nil}
+	containerEngines := engines{system, rootless}
+
+	key := rootless.key("abc")
+	if key == system.key("abc") {
+		t.Errorf("Expected keys of the same container ID on different engines to differ, got %q", key)
+	}
+
+	if _, ok := system.containerID(key); ok {
+		t.Errorf("Expected %q not to belong to the system engine", key)
+	}
+
+	containerEngine, containerID, ok := containerEngines.lookup(key)
+	if !ok || containerEngine != rootless || containerID != "abc" {
+		t.Errorf("Expected %q to be container abc of the rootless engine, got %v, %q", key, containerEngine, containerID)
+	}
+
+	if _, _, ok := containerEngines.lookup("tcp://127.0.0.1:2375/abc"); ok {
+		t.Error("Expected unknown endpoint not to be found")
+	}
+}
+
+func TestConfigValidateEndpoints(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		endpoints []string
+		valid     bool
+	}{
+		{nil, true},
+		{[]string{"unix:///var/run/docker.sock", "tcp://127.0.0.1:2375"}, true},
+		{[]string{"unix:///var/run/docker.sock", "unix:///var/run/docker.sock"}, false},
+		{[]string{"/var/run/docker.sock"}, false},
+	}
+
+	for _, testCase := range tests {
+		config := validTestConfig()
+		config.Endpoints = testCase.endpoints
+
+		if err := config.validate(); (err == nil) != testCase.valid {
+			t.Errorf("Expected validity %v of %v, got error %v", testCase.valid, testCase.endpoints, err)
+		}
+	}
+}
+
+func TestSelectNetworks(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := createTestContainer(map[string]string{})
+	containerInfo.NetworkSettings = &container.NetworkSettings{
+		Networks: map[string]*network.EndpointSettings{
+			"backend":  {IPAddress: netip.MustParseAddr("172.19.0.7")},
+			"frontend": {IPAddress: netip.MustParseAddr("172.18.0.4")},
+			"internal": {IPAddress: netip.MustParseAddr("172.20.0.2")},
+			"v6only":   {GlobalIPv6Address: netip.MustParseAddr("fd00:dead:beef::4")},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		rules    []string
+		expected []string
+	}{
+		{"all", nil, []string{"172.19.0.7", "172.18.0.4", "172.20.0.2"}},
+		{"deny", []string{"!internal"}, []string{"172.19.0.7", "172.18.0.4"}},
+		{"preferred", []string{"frontend", "backend"}, []string{"172.18.0.4"}},
+		{"fallback", []string{"missing", "backend"}, []string{"172.19.0.7"}},
+		{"no address in family", []string{"v6only", "internal"}, []string{"172.20.0.2"}},
+		{"any", []string{"missing", "*"}, []string{"172.19.0.7"}},
+		{"any but denied", []string{"!backend", "*"}, []string{"172.18.0.4"}},
+		{"none", []string{"missing"}, []string{}},
+		{"spaces", []string{" missing", " ! backend ", " * "}, []string{"172.18.0.4"}},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			ips := ipAddresses(containerInfo, ipFamilyIPv4, testCase.rules)
+
+			if !slices.Equal(ips, testCase.expected) {
+				t.Errorf("Expected IP addresses %q, got %q", testCase.expected, ips)
+			}
+		})
+	}
+}
+
+func TestContainerConfigNetworks(t *testing.T) {
+	t.Parallel()
+
+	config := containerConfig(createTestContainer(map[string]string{"ldddns.networks": "frontend, !internal,"}), Config{})
+
+	if !slices.Equal(config.Networks, []string{"frontend", "!internal"}) {
+		t.Errorf("Expected networks from label, got %q", config.Networks)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		labels          map[string]string
+		exposeByDefault bool
+		expected        bool
+	}{
+		{"exposed by default", map[string]string{}, true, true},
+		{"disabled by label", map[string]string{"ldddns.enable": "false"}, true, false},
+		{"opt-in without label", map[string]string{}, false, false},
+		{"opt-in with label", map[string]string{"ldddns.enable": "true"}, false, true},
+		{"invalid label", map[string]string{"ldddns.enable": "maybe"}, false, false},
+		// The label filter in opt-in mode only matches `true`.
+		{"opt-in with other true value", map[string]string{"ldddns.enable": "1"}, false, false},
+		{"other false value", map[string]string{"ldddns.enable": "0"}, true, true},
+		{"ignored", map[string]string{"ldddns.ignore": "true"}, true, false},
+		{"ignored but enabled", map[string]string{"ldddns.enable": "true", "ldddns.ignore": "true"}, false, false},
+		{"not ignored", map[string]string{"ldddns.ignore": "false"}, true, true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := Config{ExposeByDefault: testCase.exposeByDefault}
+
+			if got := enabled(createTestContainer(testCase.labels), config); got != testCase.expected {
+				t.Errorf("Expected enabled to be %v, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestEnabledFilter(t *testing.T) {
+	t.Parallel()
+
+	if filter := enabledFilter(make(client.Filters), Config{ExposeByDefault: true}); len(filter) != 0 {
+		t.Errorf("Expected no filter when exposing by default, got %v", filter)
+	}
+
+	filter := enabledFilter(make(client.Filters), Config{ExposeByDefault: false})
+	if !filter["label"]["ldddns.enable=true"] {
+		t.Errorf("Expected label filter in opt-in mode, got %v", filter)
+	}
+}
+
+func TestContainerConfigHostnameLookup(t *testing.T) {
+	t.Parallel()
+
+	var lookups hostname.Lookups
+
+	if err := lookups.Decode("env:VIRTUAL_HOST,containerName"); err != nil {
+		t.Fatalf("Unexpected error decoding hostname lookup: %s", err)
+	}
+
+	global := Config{HostnameLookup: lookups, IgnoreDockerComposeOneoff: true}
+
+	tests := []struct {
+		name           string
+		labels         map[string]string
+		hostnameLookup string
+		oneoff         bool
+	}{
+		{"no labels", map[string]string{}, "[env:VIRTUAL_HOST containerName]", true},
+		{
+			"lookup label",
+			map[string]string{"ldddns.hostname-lookup": "label:foo, containerName"},
+			"[label:foo containerName]",
+			true,
+		},
+		{
+			"invalid lookup label",
+			map[string]string{"ldddns.hostname-lookup": "foo"},
+			"[env:VIRTUAL_HOST containerName]",
+			true,
+		},
+		{
+			"invalid template label",
+			map[string]string{"ldddns.hostname-lookup": "template:{{.Name"},
+			"[env:VIRTUAL_HOST containerName]",
+			true,
+		},
+		{
+			"oneoff label",
+			map[string]string{"ldddns.ignore-docker-compose-oneoff": "false"},
+			"[env:VIRTUAL_HOST containerName]",
+			false,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			config := containerConfig(createTestContainer(testCase.labels), global)
+
+			if hostnameLookup := fmt.Sprint(config.HostnameLookup); hostnameLookup != testCase.hostnameLookup {
+				t.Errorf("Expected hostname lookup %s, got %s", testCase.hostnameLookup, hostnameLookup)
+			}
+
+			if config.IgnoreDockerComposeOneoff != testCase.oneoff {
+				t.Errorf("Expected ignore oneoff %v, got %v", testCase.oneoff, config.IgnoreDockerComposeOneoff)
+			}
+		})
+	}
+}
+
+//nolint:paralleltest
+func TestConfigInvalidHostnameLookup(t *testing.T) {
+	t.Setenv("LDDDNS_HOSTNAME_LOOKUP", "env:VIRTUAL_HOST,template:{{.Name")
+
+	var config Config
+
+	if err := envconfig.Process("ldddns", &config); err == nil {
+		t.Error("Expected invalid hostname lookup to fail loading the configuration")
+	}
+}
+
+func TestTXTRecords(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := createTestContainer(map[string]string{
+		"com.docker.compose.project": "shop",
+		"com.docker.compose.service": "web",
+	})
+	containerInfo.InspectResponse.Name = "/shop-web-1"
+	containerInfo.Config.Image = "nginx:latest"
+
+	tests := []struct {
+		name     string
+		keys     []string
+		expected []string
+	}{
+		{
+			"all",
+			[]string{txtContainer, txtImage, txtComposeProject, txtComposeService},
+			[]string{"container=shop-web-1", "image=nginx:latest", "compose_project=shop", "compose_service=web"},
+		},
+		{"selected", []string{txtContainer}, []string{"container=shop-web-1"}},
+		{"none", []string{}, []string{}},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			records := []string{}
+			for _, record := range txtRecords(containerInfo, testCase.keys) {
+				records = append(records, string(record))
+			}
+
+			if !slices.Equal(records, testCase.expected) {
+				t.Errorf("Expected TXT records %q, got %q", testCase.expected, records)
+			}
+		})
+	}
+
+	// The image name is too long for a TXT record string.
+	longImage := createTestContainer(map[string]string{})
+	longImage.InspectResponse.Name = "/shop-web-1"
+	longImage.Config.Image = strings.Repeat("a", internalContainer.MaxTXTStringLength-len("image=")+1)
+
+	records := txtRecords(longImage, []string{txtContainer, txtImage})
+	if len(records) != 1 || string(records[0]) != "container=shop-web-1" {
+		t.Errorf("Expected oversize TXT records to be left out, got %q", records)
+	}
+
+	if records := txtRecords(createTestContainer(map[string]string{}), []string{txtComposeProject}); len(records) != 0 {
+		t.Errorf("Expected no TXT records without values, got %q", records)
+	}
+}
+
+func TestConfigValidateTXTRecords(t *testing.T) {
+	t.Parallel()
+
+	config := validTestConfig()
+	config.TXTRecords = []string{txtContainer, "hostname"}
+
+	if err := config.validate(); err == nil {
+		t.Error("Expected unknown TXT record key to fail validation")
+	}
+}
+
+func TestServiceTXTRecords(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := createTestContainer(map[string]string{
+		"ldddns.txt.80.path":      "/admin",
+		"ldddns.txt.80.container": "renamed",
+	})
+	containerInfo.InspectResponse.Name = "/web"
+
+	http := internalContainer.Service{Type: "_http._tcp", Port: 80}
+	ssh := internalContainer.Service{Type: "_ssh._tcp", Port: 22}
+	records := serviceTXTRecords(containerInfo, []string{txtContainer}, []internalContainer.Service{http, ssh})
+
+	expected := map[internalContainer.Service][]string{
+		http: {"container=renamed", "path=/admin"},
+		ssh:  {"container=web"},
+	}
+
+	for service, expectedRecords := range expected {
+		got := []string{}
+		for _, record := range records[service] {
+			got = append(got, string(record))
+		}
+
+		if !slices.Equal(got, expectedRecords) {
+			t.Errorf("Expected TXT records %q for %s, got %q", expectedRecords, service.Type, got)
+		}
+	}
+}
+
+func TestPortInstanceName(t *testing.T) {
+	t.Parallel()
+
+	services := []internalContainer.Service{
+		{Type: "_http._tcp", Port: 8080},
+		{Type: "_http._tcp", Port: 80},
+		{Type: "_ssh._tcp", Port: 22},
+	}
+
+	expected := []string{"web (port 8080)", "web", "web"}
+
+	for i, service := range services {
+		if name := portInstanceName("web", service, services); name != expected[i] {
+			t.Errorf("Expected instance name %q for %s on %d, got %q", expected[i], service.Type, service.Port, name)
+		}
+	}
+}
+
+func TestServiceNamesSplitServices(t *testing.T) {
+	t.Parallel()
+
+	// Only port 80 is published on the host, so the two HTTP services
+	// are announced separately on the host and on the container.
+	containerInfo := createTestContainer(map[string]string{})
+	containerInfo.NetworkSettings = &container.NetworkSettings{
+		Ports: network.PortMap{
+			network.MustParsePort("80/tcp"):   {{HostIP: netip.IPv4Unspecified(), HostPort: "80"}},
+			network.MustParsePort("8080/tcp"): nil,
+		},
+	}
+	http := internalContainer.Service{Type: "_http._tcp", Port: 80}
+	alt := internalContainer.Service{Type: "_http._tcp", Port: 8080}
+	services := []internalContainer.Service{http, alt}
+
+	containerServices, hostServices := splitServices(containerInfo, services, servicePortsHost)
+	if _, ok := hostServices[http]; !ok {
+		t.Fatalf("Expected %v on the host, got %v", http, hostServices)
+	}
+
+	if _, ok := containerServices[alt]; !ok {
+		t.Fatalf("Expected %v on the container, got %v", alt, containerServices)
+	}
+
+	names := serviceNames("web", services)
+
+	if names[http] != "web" || names[alt] != "web (port 8080)" {
+		t.Errorf("Expected distinct instance names across host and container, got %v", names)
+	}
+}
+
+func TestTXTLength(t *testing.T) {
+	t.Parallel()
+
+	if length := txtLength([][]byte{[]byte("a=b"), []byte("path=/")}); length != 11 {
+		t.Errorf("Expected TXT length 11, got %d", length)
+	}
+}
+
+func TestSplitServices(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := createTestContainer(map[string]string{})
+	containerInfo.NetworkSettings = &container.NetworkSettings{
+		Ports: network.PortMap{
+			network.MustParsePort("80/tcp"): {{HostIP: netip.IPv4Unspecified(), HostPort: "8080"}},
+		},
+	}
+	http := internalContainer.Service{Type: "_http._tcp", Port: 80}
+	ssh := internalContainer.Service{Type: "_ssh._tcp", Port: 22}
+	services := []internalContainer.Service{http, ssh}
+
+	tests := []struct {
+		mode              string
+		containerServices map[internalContainer.Service]uint16
+		hostServices      map[internalContainer.Service]uint16
+	}{
+		{
+			servicePortsContainer,
+			map[internalContainer.Service]uint16{http: 80, ssh: 22},
+			map[internalContainer.Service]uint16{},
+		},
+		{servicePortsHost, map[internalContainer.Service]uint16{ssh: 22}, map[internalContainer.Service]uint16{http: 8080}},
+		{servicePortsHostOnly, map[internalContainer.Service]uint16{}, map[internalContainer.Service]uint16{http: 8080}},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.mode, func(t *testing.T) {
+			t.Parallel()
+
+			containerServices, hostServices := splitServices(containerInfo, services, testCase.mode)
+
+			if !maps.Equal(containerServices, testCase.containerServices) {
+				t.Errorf("Expected container services %v, got %v", testCase.containerServices, containerServices)
+			}
+
+			if !maps.Equal(hostServices, testCase.hostServices) {
+				t.Errorf("Expected host services %v, got %v", testCase.hostServices, hostServices)
+			}
+		})
+	}
+}
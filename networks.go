@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	internalContainer "ldddns.arnested.dk/internal/container"
+)
+
+// anyNetwork in the list of networks allows the networks not listed.
+const anyNetwork = "*"
+
+// ipAddresses returns the IP addresses of the container in the
+// configured IP family on the networks selected by the network rules.
+func ipAddresses(containerInfo internalContainer.Container, ipFamily string, networkRules []string) []string {
+	return familyAddresses(containerInfo, ipFamily, selectNetworks(containerInfo, ipFamily, networkRules))
+}
+
+// familyAddresses returns the IP addresses of the container in the IP
+// family on the named networks.
+func familyAddresses(containerInfo internalContainer.Container, ipFamily string, networks []string) []string {
+	switch ipFamily {
+	case ipFamilyIPv6:
+		return containerInfo.NetworkIPv6Addresses(networks)
+	case ipFamilyBoth:
+		return append(containerInfo.NetworkIPAddresses(networks), containerInfo.NetworkIPv6Addresses(networks)...)
+	default:
+		return containerInfo.NetworkIPAddresses(networks)
+	}
+}
+
+// selectNetworks returns the networks of the container to publish
+// addresses from.
+//
+// The network rules are network names to allow in order of preference
+// and network names prefixed with `!` to deny. `*` allows any network
+// not listed. With no rules all networks are used. With only denying
+// rules all other networks are used. Otherwise only the most preferred
+// network the container has addresses on is used. Spaces around the
+// rules, i.e. from `LDDDNS_NETWORKS=backend, !frontend`, are ignored.
+func selectNetworks(containerInfo internalContainer.Container, ipFamily string, networkRules []string) []string {
+	available := []string{}
+
+	for _, name := range containerInfo.Networks() {
+		if len(familyAddresses(containerInfo, ipFamily, []string{name})) > 0 {
+			available = append(available, name)
+		}
+	}
+
+	if len(networkRules) == 0 {
+		return available
+	}
+
+	denied := []string{}
+	preferred := []string{}
+
+	for _, rule := range networkRules {
+		rule = strings.TrimSpace(rule)
+
+		if name, found := strings.CutPrefix(rule, "!"); found {
+			denied = append(denied, strings.TrimSpace(name))
+		} else {
+			preferred = append(preferred, rule)
+		}
+	}
+
+	available = slices.DeleteFunc(available, func(name string) bool {
+		return slices.Contains(denied, name)
+	})
+
+	if len(preferred) == 0 {
+		return available
+	}
+
+	for _, name := range preferred {
+		if name == anyNetwork {
+			for _, other := range available {
+				if !slices.Contains(preferred, other) {
+					return []string{other}
+				}
+			}
+
+			continue
+		}
+
+		if slices.Contains(available, name) {
+			return []string{name}
+		}
+	}
+
+	return []string{}
+}
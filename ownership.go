@@ -0,0 +1,219 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	internalContainer "ldddns.arnested.dk/internal/container"
+	"ldddns.arnested.dk/internal/log"
+)
+
+// Policies for deciding which container gets a hostname claimed by
+// several containers.
+const (
+	hostnamePolicyFirstWins  = "first-wins"
+	hostnamePolicyNewestWins = "newest-wins"
+	hostnamePolicyPriority   = "priority"
+)
+
+func validHostnamePolicy(policy string) bool {
+	return policy == hostnamePolicyFirstWins ||
+		policy == hostnamePolicyNewestWins ||
+		policy == hostnamePolicyPriority
+}
+
+// hostnameClaim is a container's claim on its hostnames.
+type hostnameClaim struct {
+	containerID   string
+	containerName string
+	started       time.Time
+	priority      int
+}
+
+// newHostnameClaim creates the claim of the container with the key.
+// The priority is read from the `ldddns.priority` label.
+func newHostnameClaim(key string, containerInfo internalContainer.Container) hostnameClaim {
+	//nolint:errcheck
+	started, _ := time.Parse(time.RFC3339Nano, containerInfo.State.StartedAt)
+
+	priority := 0
+
+	if value, ok := containerInfo.Config.Labels[labelPrefix+"priority"]; ok {
+		var err error
+
+		priority, err = strconv.Atoi(value)
+		if err != nil {
+			log.Logf(
+				log.PriWarning,
+				"Ignoring invalid %spriority label %q on container %s",
+				labelPrefix,
+				value,
+				containerInfo.ID,
+			)
+		}
+	}
+
+	return hostnameClaim{
+		containerID:   key,
+		containerName: containerInfo.Name(),
+		started:       started,
+		priority:      priority,
+	}
+}
+
+// beats tells whether the claim wins over the other claim. Ties are
+// decided by the start time and at last the container ID so the
+// outcome never depends on the order of events.
+func (c hostnameClaim) beats(other hostnameClaim, policy string) bool {
+	if policy == hostnamePolicyPriority && c.priority != other.priority {
+		return c.priority > other.priority
+	}
+
+	if !c.started.Equal(other.started) {
+		if policy == hostnamePolicyNewestWins {
+			return c.started.After(other.started)
+		}
+
+		return c.started.Before(other.started)
+	}
+
+	return c.containerID < other.containerID
+}
+
+// hostnameOwners keeps track of which containers claim which hostnames
+// and decides who owns them.
+type hostnameOwners struct {
+	policy string
+	// claims maps hostnames to the claims of containers by
+	// container ID.
+	claims map[string]map[string]hostnameClaim
+	// hostnames maps container IDs to their claimed hostnames.
+	hostnames map[string][]string
+	mutex     sync.Mutex
+}
+
+func newHostnameOwners(policy string) *hostnameOwners {
+	return &hostnameOwners{
+		policy:    policy,
+		claims:    make(map[string]map[string]hostnameClaim),
+		hostnames: make(map[string][]string),
+		mutex:     sync.Mutex{},
+	}
+}
+
+// claim replaces the hostnames claimed by a container. It returns the
+// hostnames owned by the container, the IDs of other containers that
+// gained or lost a hostname and must be published again, and the IDs
+// of the containers the container took hostnames from. The latter
+// still publish the hostnames until they are withdrawn.
+func (o *hostnameOwners) claim(claim hostnameClaim, hostnames []string) ([]string, []string, []string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	affected := append(slices.Clone(o.hostnames[claim.containerID]), hostnames...)
+	before := make(map[string]hostnameClaim, len(affected))
+
+	for _, hostname := range affected {
+		before[hostname] = o.owner(hostname)
+	}
+
+	for _, hostname := range o.hostnames[claim.containerID] {
+		delete(o.claims[hostname], claim.containerID)
+
+		if len(o.claims[hostname]) == 0 {
+			delete(o.claims, hostname)
+		}
+	}
+
+	delete(o.hostnames, claim.containerID)
+
+	for _, hostname := range hostnames {
+		if _, ok := o.claims[hostname]; !ok {
+			o.claims[hostname] = make(map[string]hostnameClaim)
+		}
+
+		o.claims[hostname][claim.containerID] = claim
+	}
+
+	if len(hostnames) > 0 {
+		o.hostnames[claim.containerID] = slices.Clone(hostnames)
+	}
+
+	owned := []string{}
+	changed := []string{}
+	taken := []string{}
+
+	for hostname, previous := range before {
+		owner := o.owner(hostname)
+		if owner.containerID == previous.containerID {
+			continue
+		}
+
+		if previous.containerID != "" && owner.containerID != "" {
+			log.Logf(
+				log.PriNotice,
+				"Hostname %q moved from container %s to container %s",
+				hostname,
+				previous.containerName,
+				owner.containerName,
+			)
+		}
+
+		if owner.containerID == claim.containerID && previous.containerID != "" {
+			taken = append(taken, previous.containerID)
+		}
+
+		for _, containerID := range []string{previous.containerID, owner.containerID} {
+			if containerID != claim.containerID && containerID != "" {
+				changed = append(changed, containerID)
+			}
+		}
+	}
+
+	for _, hostname := range hostnames {
+		owner := o.owner(hostname)
+
+		if owner.containerID == claim.containerID {
+			owned = append(owned, hostname)
+
+			continue
+		}
+
+		log.Logf(
+			log.PriNotice,
+			"Not publishing hostname %q for container %s: it is owned by container %s",
+			hostname,
+			claim.containerName,
+			owner.containerName,
+		)
+	}
+
+	slices.Sort(changed)
+	slices.Sort(taken)
+
+	return owned, slices.Compact(changed), slices.Compact(taken)
+}
+
+// release the hostnames claimed by a container. It returns the IDs of
+// containers taking over the hostnames.
+func (o *hostnameOwners) release(containerID string) []string {
+	_, changed, _ := o.claim(hostnameClaim{containerID: containerID}, nil)
+
+	return changed
+}
+
+// owner returns the winning claim on a hostname or an empty claim if
+// nobody claims it. Must be called with the lock held.
+func (o *hostnameOwners) owner(hostname string) hostnameClaim {
+	var owner hostnameClaim
+
+	for _, claim := range o.claims[hostname] {
+		if owner.containerID == "" || claim.beats(owner, o.policy) {
+			owner = claim
+		}
+	}
+
+	return owner
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// queue is a set of container IDs waiting to be handled again.
+type queue struct {
+	pending map[string]struct{}
+	// ready receives a value when there are pending container IDs.
+	ready chan struct{}
+	mutex sync.Mutex
+}
+
+func newQueue() *queue {
+	return &queue{
+		pending: make(map[string]struct{}),
+		ready:   make(chan struct{}, 1),
+		mutex:   sync.Mutex{},
+	}
+}
+
+// push container IDs onto the queue. It never blocks.
+func (q *queue) push(containerIDs ...string) {
+	q.mutex.Lock()
+
+	for _, containerID := range containerIDs {
+		q.pending[containerID] = struct{}{}
+	}
+
+	q.mutex.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drain returns the pending container IDs and empties the queue.
+func (q *queue) drain() []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	containerIDs := make([]string, 0, len(q.pending))
+	for containerID := range q.pending {
+		containerIDs = append(containerIDs, containerID)
+	}
+
+	clear(q.pending)
+	slices.Sort(containerIDs)
+
+	return containerIDs
+}
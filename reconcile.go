@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/events"
+	"github.com/moby/moby/client"
+	"ldddns.arnested.dk/internal/log"
+)
+
+// reconcileLoop reconciles the published entry groups with the
+// running containers every interval until the context is cancelled.
+func reconcileLoop(
+	ctx context.Context,
+	config Config,
+	docker *engine,
+	egs *entryGroups,
+	dns *dnsServer,
+) {
+	if config.ReconcileInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(config.ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reconcile(ctx, config, docker, egs, dns)
+		}
+	}
+}
+
+// reconcile publishes running containers we have no entry group for
+// and resets entry groups of containers no longer running. It catches
+// up on Docker events we might have missed.
+func reconcile(
+	ctx context.Context,
+	config Config,
+	docker *engine,
+	egs *entryGroups,
+	dns *dnsServer,
+) {
+	published := make(map[string]bool)
+
+	// Only reconcile the containers of this engine.
+	for key, empty := range egs.published() {
+		if containerID, ok := docker.containerID(key); ok {
+			published[containerID] = empty
+		}
+	}
+
+	actions, err := reconcileActions(ctx, config, docker, published)
+	if err != nil {
+		log.Logf(log.PriErr, "reconciling: %v", err)
+
+		return
+	}
+
+	for _, containerID := range slices.Sorted(maps.Keys(actions)) {
+		if actions[containerID] == "destroy" {
+			removeContainer(docker, containerID, egs, dns)
+
+			continue
+		}
+
+		err := handleContainer(ctx, docker, containerID, egs, dns, actions[containerID], config)
+		if err != nil {
+			log.Logf(log.PriErr, "reconciling: handling container: %v", err)
+		}
+	}
+}
+
+// reconcileActions compares the running containers with the published
+// containers and whether their entry groups are empty. It returns how
+// to handle each container out of sync: publish it (`start`), reset
+// its records (`die`) or forget it (`destroy`).
+func reconcileActions(
+	ctx context.Context,
+	config Config,
+	docker *engine,
+	published map[string]bool,
+) (map[string]events.Action, error) {
+	filter := enabledFilter(make(client.Filters), config)
+
+	result, err := docker.ContainerList(ctx, client.ContainerListOptions{Filters: filter})
+	if err != nil {
+		return nil, fmt.Errorf("getting container list: %w", err)
+	}
+
+	running := make(map[string]bool, len(result.Items))
+	for _, container := range result.Items {
+		running[container.ID] = true
+	}
+
+	actions := make(map[string]events.Action)
+
+	for containerID := range running {
+		if _, ok := published[containerID]; ok {
+			continue
+		}
+
+		log.Logf(log.PriNotice, "Reconciling: publishing missing container %s", containerID)
+
+		actions[containerID] = "start"
+	}
+
+	for containerID, empty := range published {
+		if running[containerID] {
+			continue
+		}
+
+		status, err := reconcileStatus(ctx, docker, containerID)
+		if err != nil {
+			// Leave the container as it is until the next
+			// reconcile.
+			log.Logf(log.PriWarning, "Reconciling: %v", err)
+
+			continue
+		}
+
+		switch {
+		case status == "destroy":
+			log.Logf(log.PriNotice, "Reconciling: forgetting removed container %s", containerID)
+		case status == "start":
+			log.Logf(log.PriNotice, "Reconciling: republishing restarted container %s", containerID)
+		case !empty:
+			log.Logf(log.PriNotice, "Reconciling: removing records of stopped container %s", containerID)
+		default:
+			continue
+		}
+
+		actions[containerID] = status
+	}
+
+	return actions, nil
+}
+
+// reconcileStatus double checks the state of a container that was not
+// running when we listed the containers. It might have been started
+// or removed since. Only a container Docker cannot find is removed.
+// Any other error leaves the state unknown.
+func reconcileStatus(ctx context.Context, docker *engine, containerID string) (events.Action, error) {
+	result, err := docker.ContainerInspect(ctx, containerID, client.ContainerInspectOptions{})
+
+	switch {
+	case cerrdefs.IsNotFound(err):
+		return "destroy", nil
+	case err != nil:
+		return "", fmt.Errorf("inspecting container %s: %w", containerID, err)
+	case result.Container.State == nil || !result.Container.State.Running:
+		return "die", nil
+	default:
+		return "start", nil
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	internalContainer "ldddns.arnested.dk/internal/container"
+)
+
+// Modes for the ports services are announced with.
+const (
+	// servicePortsContainer announces the ports of the containers
+	// pointing at the containers' hostnames.
+	servicePortsContainer = "container"
+	// servicePortsHost announces the ports published on the host
+	// pointing at the host's name. Ports not published on the host
+	// are announced like servicePortsContainer.
+	servicePortsHost = "host"
+	// servicePortsHostOnly is like servicePortsHost but leaves out
+	// the ports not published on the host.
+	servicePortsHostOnly = "host-only"
+)
+
+// Which hostnames of a container services are announced for.
+const (
+	serviceHostnamesAll   = "all"
+	serviceHostnamesFirst = "first"
+)
+
+func validServiceHostnames(hostnames string) bool {
+	return hostnames == serviceHostnamesAll || hostnames == serviceHostnamesFirst
+}
+
+func validServicePorts(mode string) bool {
+	return mode == servicePortsContainer || mode == servicePortsHost || mode == servicePortsHostOnly
+}
+
+// splitServices returns the services to announce on the container's
+// hostname and the services to announce on the host's name, each with
+// the port to announce: the container's port or the port published on
+// the host.
+func splitServices(
+	containerInfo internalContainer.Container,
+	services []internalContainer.Service,
+	mode string,
+) (map[internalContainer.Service]uint16, map[internalContainer.Service]uint16) {
+	containerServices := map[internalContainer.Service]uint16{}
+	hostServices := map[internalContainer.Service]uint16{}
+
+	for _, service := range services {
+		if mode != servicePortsHost && mode != servicePortsHostOnly {
+			containerServices[service] = service.Port
+
+			continue
+		}
+
+		if hostPort, ok := containerInfo.HostPort(service); ok {
+			hostServices[service] = hostPort
+		} else if mode == servicePortsHost {
+			containerServices[service] = service.Port
+		}
+	}
+
+	return containerServices, hostServices
+}
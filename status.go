@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"ldddns.arnested.dk/internal/log"
+)
+
+// daemonStatus keeps track of the status we report to systemd.
+type daemonStatus struct {
+	version string
+	config  Config
+	groups  int
+	// problems are the reasons we are degraded keyed by the
+	// component having the problem.
+	problems map[string]error
+	mutex    sync.Mutex
+}
+
+func newDaemonStatus(version string, config Config) *daemonStatus {
+	return &daemonStatus{
+		version:  version,
+		config:   config,
+		groups:   0,
+		problems: make(map[string]error),
+		mutex:    sync.Mutex{},
+	}
+}
+
+// degraded reports that a component is unavailable. A nil status
+// ignores it.
+func (s *daemonStatus) degraded(component string, problem error) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.problems[component] = problem
+	s.mutex.Unlock()
+
+	err := s.notify("")
+	if err != nil {
+		log.Logf(log.PriErr, "notifying systemd about %s being unavailable: %v", component, err)
+	}
+}
+
+// recovered reports that a component is available again. A nil status
+// ignores it.
+func (s *daemonStatus) recovered(component string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	_, wasDegraded := s.problems[component]
+	delete(s.problems, component)
+	s.mutex.Unlock()
+
+	if !wasDegraded {
+		return
+	}
+
+	err := s.notify("")
+	if err != nil {
+		log.Logf(log.PriErr, "notifying systemd about %s being available: %v", component, err)
+	}
+}
+
+// setGroups updates the number of live entry groups. A nil status
+// ignores it.
+func (s *daemonStatus) setGroups(groups int) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	changed := s.groups != groups
+	s.groups = groups
+	s.mutex.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	return s.notify("")
+}
+
+// notify systemd about the state (i.e. `READY=1`) along with the
+// current status.
+func (s *daemonStatus) notify(state string) error {
+	cfg, err := json.Marshal(s.config)
+	if err != nil {
+		return fmt.Errorf("could not marshal config as JSON: %w", err)
+	}
+
+	s.mutex.Lock()
+	status := "STATUS=version " + s.version + "; "
+
+	for _, component := range slices.Sorted(maps.Keys(s.problems)) {
+		status += fmt.Sprintf("%s unavailable: %v; ", component, s.problems[component])
+	}
+
+	status += fmt.Sprintf("%d entry groups; %s", s.groups, cfg)
+	s.mutex.Unlock()
+
+	// Keep NOTIFY_SOCKET so later status updates reach systemd too.
+	_, err = daemon.SdNotify(false, strings.TrimPrefix(state+"\n"+status, "\n"))
+	if err != nil {
+		return fmt.Errorf("failed to notify systemd: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	internalContainer "ldddns.arnested.dk/internal/container"
+	"ldddns.arnested.dk/internal/log"
+)
+
+// Keys of the DNS-SD TXT records published with the services of a
+// container.
+const (
+	txtContainer      = "container"
+	txtImage          = "image"
+	txtComposeProject = "compose_project"
+	txtComposeService = "compose_service"
+)
+
+// maxTXTLength is the size a TXT record should stay below to fit in a
+// single multicast DNS packet (RFC 6763 section 6.2).
+const maxTXTLength = 1300
+
+// knownTXTKey tells whether the key is one of the keys of the
+// container's metadata we can publish.
+func knownTXTKey(key string) bool {
+	switch key {
+	case txtContainer, txtImage, txtComposeProject, txtComposeService:
+		return true
+	default:
+		return false
+	}
+}
+
+// txtRecords returns the TXT records with the container's metadata
+// for the keys. Keys without a value and records too long for a TXT
+// record string are left out.
+func txtRecords(containerInfo internalContainer.Container, keys []string) [][]byte {
+	records := [][]byte{}
+
+	for _, key := range keys {
+		value := txtValue(containerInfo, key)
+		if value == "" {
+			continue
+		}
+
+		record := key + "=" + value
+		if len(record) > internalContainer.MaxTXTStringLength {
+			log.Logf(
+				log.PriWarning,
+				"Leaving out the %s TXT record of container %s: it is %d bytes, longer than %d bytes",
+				key,
+				containerInfo.Name(),
+				len(record),
+				internalContainer.MaxTXTStringLength,
+			)
+
+			continue
+		}
+
+		records = append(records, []byte(record))
+	}
+
+	return records
+}
+
+func txtValue(containerInfo internalContainer.Container, key string) string {
+	switch key {
+	case txtContainer:
+		return containerInfo.Name()
+	case txtImage:
+		return containerInfo.Config.Image
+	case txtComposeProject:
+		return containerInfo.Config.Labels["com.docker.compose.project"]
+	case txtComposeService:
+		return containerInfo.Config.Labels["com.docker.compose.service"]
+	default:
+		return ""
+	}
+}
+
+// serviceTXTRecords returns the TXT records of each service: the
+// records with the container's metadata merged with the records
+// declared with labels for the port of the service.
+func serviceTXTRecords(
+	containerInfo internalContainer.Container,
+	keys []string,
+	services []internalContainer.Service,
+) map[internalContainer.Service][][]byte {
+	metadata := txtRecords(containerInfo, keys)
+	records := make(map[internalContainer.Service][][]byte, len(services))
+
+	for _, service := range services {
+		records[service] = mergeTXTRecords(metadata, containerInfo.ServiceTXT(service.Port))
+
+		if length := txtLength(records[service]); length > maxTXTLength {
+			log.Logf(
+				log.PriWarning,
+				"The TXT record of service %q of container %s is %d bytes, it should be less than %d bytes",
+				service.Type,
+				containerInfo.Name(),
+				length,
+				maxTXTLength,
+			)
+		}
+	}
+
+	return records
+}
+
+// mergeTXTRecords returns the records with the declared records
+// replacing the records with the same key.
+func mergeTXTRecords(records [][]byte, declared [][]byte) [][]byte {
+	merged := slices.DeleteFunc(slices.Clone(records), func(record []byte) bool {
+		return slices.ContainsFunc(declared, func(declaredRecord []byte) bool {
+			return strings.EqualFold(txtKey(record), txtKey(declaredRecord))
+		})
+	})
+
+	return append(merged, declared...)
+}
+
+// txtKey returns the key of a TXT record string.
+func txtKey(record []byte) string {
+	key, _, _ := strings.Cut(string(record), "=")
+
+	return key
+}
+
+// txtLength returns the size of the TXT record on the wire: each string
+// is prefixed by its length.
+func txtLength(records [][]byte) int {
+	length := 0
+
+	for _, record := range records {
+		length += 1 + len(record)
+	}
+
+	return length
+}